@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+// accountIndexID is the secret store entry holding the account index. It
+// can't clash with a user ID, as those are UUIDs.
+const accountIndexID = "bw-bio-handler-account-index"
+
+const accountIndexVersion = 1
+
+// account is an enrolled Bitwarden account.
+type account struct {
+	UserID      string    `json:"userId"`
+	Email       string    `json:"email"`
+	Name        string    `json:"name,omitempty"`
+	APIURL      string    `json:"apiUrl"`
+	IdentityURL string    `json:"identityUrl"`
+	EnrolledAt  time.Time `json:"enrolledAt"`
+	// ExpiresAt is set when the key was stored with a maximum age.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// accountIndex lists the enrolled accounts. It is stored next to the keys,
+// so that it moves along with them.
+type accountIndex struct {
+	Version  int       `json:"version"`
+	Accounts []account `json:"accounts"`
+}
+
+func loadAccountIndex(store secret.SecretStore) (*accountIndex, error) {
+	idx := &accountIndex{Version: accountIndexVersion}
+	value, err := store.GetSecret(accountIndexID)
+	if errors.Is(err, secret.ErrNotFound) {
+		return idx, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(value), idx); err != nil {
+		return nil, fmt.Errorf("invalid account index: %v", err)
+	}
+	if idx.Version > accountIndexVersion {
+		return nil, fmt.Errorf("account index version %d is newer than supported version %d", idx.Version, accountIndexVersion)
+	}
+	return idx, nil
+}
+
+func (idx *accountIndex) save(changes *changeSet, store secret.SecretStore, summary string) error {
+	idx.Version = accountIndexVersion
+	sort.Slice(idx.Accounts, func(i, j int) bool {
+		return idx.Accounts[i].Email < idx.Accounts[j].Email
+	})
+	bs, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return changes.setIndex(store, string(bs), summary)
+}
+
+// find returns the account with the given user ID, email or name.
+func (idx *accountIndex) find(query string) (*account, error) {
+	var found *account
+	for i, a := range idx.Accounts {
+		if a.UserID != query && a.Email != query && a.Name != query {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%q matches more than one account, use the user id", query)
+		}
+		found = &idx.Accounts[i]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no enrolled account %q", query)
+	}
+	return found, nil
+}
+
+// put adds the account, or replaces the one with the same user ID. The name
+// of a replaced account is kept.
+func (idx *accountIndex) put(a account) {
+	for i, existing := range idx.Accounts {
+		if existing.UserID == a.UserID {
+			if a.Name == "" {
+				a.Name = existing.Name
+			}
+			idx.Accounts[i] = a
+			return
+		}
+	}
+	idx.Accounts = append(idx.Accounts, a)
+}
+
+func (idx *accountIndex) remove(userID string) {
+	for i, a := range idx.Accounts {
+		if a.UserID == userID {
+			idx.Accounts = append(idx.Accounts[:i], idx.Accounts[i+1:]...)
+			return
+		}
+	}
+}
+
+// accountEmail returns the email of the enrolled account, or "" if it isn't
+// in the index.
+func accountEmail(store secret.SecretStore, userID string) string {
+	idx, err := loadAccountIndex(store)
+	if err != nil {
+		return ""
+	}
+	a, err := idx.find(userID)
+	if err != nil {
+		return ""
+	}
+	return a.Email
+}
+
+// removeAccount deletes the key of the user, its browser copies and its
+// index entry.
+func removeAccount(changes *changeSet, store secret.SecretStore, userID string) error {
+	ps, err := loadPairings()
+	if err != nil {
+		return err
+	}
+	for _, id := range append([]string{userID}, ps.copyIDs(userID)...) {
+		if err := changes.deleteSecret(store, id); err != nil {
+			return fmt.Errorf("failed to delete secret: %v", err)
+		}
+	}
+	idx, err := loadAccountIndex(store)
+	if err != nil {
+		return err
+	}
+	idx.remove(userID)
+	return idx.save(changes, store, "remove "+userID)
+}
+
+type accountsResult struct {
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	DryRun   bool      `json:"dryRun,omitempty"`
+	Accounts []account `json:"accounts,omitempty"`
+	// Enrolled is set by show, when the key of the account is present in the
+	// secret store.
+	Enrolled *bool    `json:"enrolled,omitempty"`
+	Changes  []change `json:"changes,omitempty"`
+}
+
+func accountsUsage() {
+	fmt.Fprintf(os.Stderr, `
+Usage of bw-bio-handler accounts:
+
+	bw-bio-handler accounts [command] [flags]
+
+Commands:
+
+	list                    list the enrolled accounts
+	add                     enroll an account, takes the same flags as enroll
+	show <account>          show an account and whether its key is stored
+	rename <account> <name> give an account a name
+	remove <account>        delete the key and index entry of an account
+
+Accounts can be referred to by user id, email or name.
+`[1:])
+}
+
+func runAccounts(args []string) int {
+	if len(args) == 0 {
+		accountsUsage()
+		return 2
+	}
+	if args[0] == "add" {
+		return runEnroll(args[1:])
+	}
+
+	fs := flag.NewFlagSet("accounts "+args[0], flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only print what would be changed")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	p := newPrinter(*jsonOutput)
+	changes := &changeSet{p: p, dryRun: *dryRun}
+	res := &accountsResult{Status: "ok", DryRun: *dryRun}
+	err := accounts(p, changes, args[0], fs.Args(), res)
+	res.Changes = changes.changes
+	if err == errUsage {
+		accountsUsage()
+		return 2
+	} else if err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+		p.result(res)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	p.result(res)
+	return 0
+}
+
+var errUsage = errors.New("invalid usage")
+
+func accounts(p *printer, changes *changeSet, command string, args []string, res *accountsResult) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("could not load config: %v", err)
+	}
+	store, _, err := openSecretStore(cfg)
+	if err != nil {
+		return err
+	}
+	return accountsCommand(p, changes, store, command, args, res)
+}
+
+// accountsCommand runs the accounts command on the accounts of the store.
+func accountsCommand(p *printer, changes *changeSet, store secret.SecretStore, command string, args []string, res *accountsResult) error {
+	idx, err := loadAccountIndex(store)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "list":
+		res.Accounts = idx.Accounts
+		if !p.json {
+			printAccounts(idx.Accounts)
+		}
+	case "show":
+		if len(args) != 1 {
+			return errUsage
+		}
+		a, err := idx.find(args[0])
+		if err != nil {
+			return err
+		}
+		_, err = store.GetSecret(a.UserID)
+		if err != nil && !errors.Is(err, secret.ErrNotFound) {
+			return err
+		}
+		enrolled := err == nil
+		res.Accounts = []account{*a}
+		res.Enrolled = &enrolled
+		p.Printf("User ID:      %s\n", a.UserID)
+		p.Printf("Email:        %s\n", a.Email)
+		if a.Name != "" {
+			p.Printf("Name:         %s\n", a.Name)
+		}
+		p.Printf("API URL:      %s\n", a.APIURL)
+		p.Printf("Identity URL: %s\n", a.IdentityURL)
+		p.Printf("Enrolled at:  %s\n", a.EnrolledAt.Local().Format(time.RFC1123))
+		if a.ExpiresAt != nil {
+			p.Printf("Expires at:   %s\n", a.ExpiresAt.Local().Format(time.RFC1123))
+		}
+		p.Printf("Key stored:   %t\n", enrolled)
+	case "rename":
+		if len(args) != 2 {
+			return errUsage
+		}
+		a, err := idx.find(args[0])
+		if err != nil {
+			return err
+		}
+		a.Name = args[1]
+		renamed := *a
+		res.Accounts = []account{renamed}
+		if err := idx.save(changes, store, "rename "+renamed.UserID+" to "+renamed.Name); err != nil {
+			return err
+		}
+		p.Printf("Renamed %s to %s.\n", renamed.Email, renamed.Name)
+	case "remove":
+		if len(args) != 1 {
+			return errUsage
+		}
+		a, err := idx.find(args[0])
+		if err != nil {
+			return err
+		}
+		res.Accounts = []account{*a}
+		if err := removeAccount(changes, store, a.UserID); err != nil {
+			return err
+		}
+		p.Printf("Removed %s.\n", a.Email)
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+func printAccounts(accounts []account) {
+	if len(accounts) == 0 {
+		fmt.Println("No accounts enrolled.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER ID\tEMAIL\tNAME\tSERVER\tENROLLED\tEXPIRES")
+	for _, a := range accounts {
+		expires := ""
+		if a.ExpiresAt != nil {
+			expires = a.ExpiresAt.Local().Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", a.UserID, a.Email, a.Name, a.APIURL, a.EnrolledAt.Local().Format("2006-01-02"), expires)
+	}
+	w.Flush()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+func TestAccountIndex(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	store := secret.NewMemorySecretStore()
+	p := newPrinter(true)
+	changes := &changeSet{p: p}
+
+	idx, err := loadAccountIndex(store)
+	if err != nil || len(idx.Accounts) != 0 {
+		t.Fatalf("loadAccountIndex() = %+v, %v for an empty store", idx, err)
+	}
+	enrolledAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	expiresAt := enrolledAt.Add(time.Hour)
+	idx.put(account{UserID: "b", Email: "b@example.com", EnrolledAt: enrolledAt, ExpiresAt: &expiresAt})
+	idx.put(account{UserID: "a", Email: "a@example.com", Name: "work", EnrolledAt: enrolledAt})
+	// Enrolling again keeps the name.
+	idx.put(account{UserID: "a", Email: "a@example.com", APIURL: "https://api.example.com", EnrolledAt: enrolledAt})
+	if err := idx.save(changes, store, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err = loadAccountIndex(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Version != accountIndexVersion || len(idx.Accounts) != 2 {
+		t.Fatalf("loadAccountIndex() = %+v", idx)
+	}
+	a := idx.Accounts[0]
+	if a.UserID != "a" || a.Name != "work" || a.APIURL != "https://api.example.com" || !a.EnrolledAt.Equal(enrolledAt) {
+		t.Errorf("first account = %+v, want a with its name kept", a)
+	}
+	if b := idx.Accounts[1]; b.ExpiresAt == nil || !b.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("second account = %+v, want b expiring at %s", b, expiresAt)
+	}
+	for _, query := range []string{"a", "a@example.com", "work"} {
+		if a, err := idx.find(query); err != nil || a.UserID != "a" {
+			t.Errorf("find(%q) = %v, %v", query, a, err)
+		}
+	}
+	if _, err := idx.find("c"); err == nil {
+		t.Error("find() found an account that isn't enrolled")
+	}
+
+	if err := store.SetSecret(accountIndexID, `{"version": 99}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadAccountIndex(store); err == nil {
+		t.Error("loadAccountIndex() accepted a newer version")
+	}
+}
+
+func TestAccountsCommand(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	store := secret.NewMemorySecretStore()
+	p := newPrinter(true)
+	idx := &accountIndex{Accounts: []account{
+		{UserID: "a", Email: "a@example.com"},
+		{UserID: "b", Email: "b@example.com"},
+	}}
+	if err := idx.save(&changeSet{p: p}, store, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", copyID("a", "app")} {
+		if err := store.SetSecret(id, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ps := &pairings{Browsers: []pairedBrowser{{AppID: "app", Browser: "firefox"}}}
+	if err := ps.save(); err != nil {
+		t.Fatal(err)
+	}
+	run := func(command string, args ...string) (*accountsResult, error) {
+		res := &accountsResult{}
+		return res, accountsCommand(p, &changeSet{p: p}, store, command, args, res)
+	}
+
+	if res, err := run("list"); err != nil || len(res.Accounts) != 2 {
+		t.Fatalf("list = %+v, %v", res, err)
+	}
+	res, err := run("show", "a@example.com")
+	if err != nil || len(res.Accounts) != 1 || res.Accounts[0].UserID != "a" || res.Enrolled == nil || !*res.Enrolled {
+		t.Fatalf("show = %+v, %v, want a with its key stored", res, err)
+	}
+	if res, err := run("show", "b"); err != nil || *res.Enrolled {
+		t.Fatalf("show = %+v, %v, want b without a key", res, err)
+	}
+
+	if _, err := run("rename", "a", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := run("show", "work"); err != nil || res.Accounts[0].UserID != "a" {
+		t.Fatalf("show after rename = %+v, %v", res, err)
+	}
+
+	if _, err := run("remove", "work"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", copyID("a", "app")} {
+		if _, err := store.GetSecret(id); !errors.Is(err, secret.ErrNotFound) {
+			t.Errorf("GetSecret(%q) after remove: %v, want not found", id, err)
+		}
+	}
+	if res, err := run("list"); err != nil || len(res.Accounts) != 1 || res.Accounts[0].UserID != "b" {
+		t.Fatalf("list after remove = %+v, %v", res, err)
+	}
+
+	for _, args := range [][]string{{"show"}, {"rename", "b"}, {"remove"}, {"frobnicate"}} {
+		if _, err := run(args[0], args[1:]...); err != errUsage {
+			t.Errorf("%v: %v, want a usage error", args, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/quexten/bw-bio-handler/biometrics"
+	"github.com/quexten/bw-bio-handler/logging"
+)
+
+// pamService is the PAM service installed for the pam authentication method.
+const pamService = "bw-bio-handler"
+
+// newAuthenticator returns the authenticator selected by
+// $BW_BIO_AUTHENTICATOR or the config file, the default of the platform if
+// none is.
+func newAuthenticator(cfg *config) (biometrics.Authenticator, error) {
+	return biometrics.NewAuthenticator(biometrics.Options{
+		Method:     authMethod(cfg),
+		Feedback:   authFeedback,
+		Prompt:     authPrompt,
+		PAMService: firstNonEmpty(os.Getenv("BW_BIO_PAM_SERVICE"), cfg.pamService, pamService),
+	})
+}
+
+// authMethod returns the authentication method selected by
+// $BW_BIO_AUTHENTICATOR or the config file, "" for the default.
+func authMethod(cfg *config) string {
+	return firstNonEmpty(os.Getenv("BW_BIO_AUTHENTICATOR"), cfg.authenticator)
+}
+
+// authFeedback shows what the user has to do to authenticate, f.e. to scan
+// their finger again, as the handler has no window of its own.
+func authFeedback(message string) {
+	if err := sendNotification("Bitwarden biometric unlock", message); err != nil {
+		logging.Errorf("Could not send notification: %v", err)
+	}
+}
+
+// authPrompt asks for the input an authentication method needs with
+// pinentry.
+func authPrompt(reason string, prompt string, echo bool) (string, error) {
+	answer, err := askPinentry(reason, prompt, "")
+	if errors.Is(err, errPINCanceled) {
+		return "", biometrics.ErrCanceled
+	}
+	return answer, err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestBackup describes a manifest of another application, usually the
+// official desktop app, that was replaced by install.
+type manifestBackup struct {
+	Browser      string `json:"browser"`
+	OriginalPath string `json:"originalPath"`
+	HostName     string `json:"hostName"`
+	// Target is the executable the backed up manifest launched.
+	Target    string    `json:"target"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// backupPaths returns where the manifest content and its metadata are backed
+// up for the given browser.
+func backupPaths(b browser, fileName string) (content string, meta string, err error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", "", err
+	}
+	// Browsers sharing a manifest directory share the backup, too.
+	name := b.name
+	for _, other := range browsers {
+		if other.manifestDir == b.manifestDir {
+			name = other.name
+			break
+		}
+	}
+	content = filepath.Join(dir, "backups", name, fileName)
+	return content, content + ".meta", nil
+}
+
+// backupManifest backs up the manifest at path unless it belongs to
+// bw-bio-handler. It reports whether a backup was made.
+func backupManifest(p *printer, changes *changeSet, b browser, path string, hostName string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	owned, err := isHandlerManifest(path)
+	if err != nil || owned {
+		return false, err
+	}
+
+	var manifest struct {
+		Path string `json:"path"`
+	}
+	_ = json.Unmarshal(content, &manifest)
+	sum := sha256.Sum256(content)
+	backup := manifestBackup{
+		Browser:      b.name,
+		OriginalPath: path,
+		HostName:     hostName,
+		Target:       manifest.Path,
+		SHA256:       hex.EncodeToString(sum[:]),
+		CreatedAt:    time.Now().UTC(),
+	}
+	meta, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return false, err
+	}
+
+	contentPath, metaPath, err := backupPaths(b, filepath.Base(path))
+	if err != nil {
+		return false, err
+	}
+	p.Printf("Backing up the existing manifest of %s to %s\n", manifest.Path, contentPath)
+	if err := changes.writeFile(contentPath, content, 0600); err != nil {
+		return false, err
+	}
+	if err := changes.writeFile(metaPath, append(meta, '\n'), 0600); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// loadBackup returns the backed up manifest for the browser, or nil if there
+// is none.
+func loadBackup(b browser, fileName string) (*manifestBackup, []byte, error) {
+	contentPath, metaPath, err := backupPaths(b, fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := os.ReadFile(metaPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	var backup manifestBackup
+	if err := json.Unmarshal(meta, &backup); err != nil {
+		return nil, nil, fmt.Errorf("invalid backup metadata %s: %v", metaPath, err)
+	}
+	content, err := os.ReadFile(contentPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != backup.SHA256 {
+		return nil, nil, fmt.Errorf("backup %s does not match its checksum", contentPath)
+	}
+	return &backup, content, nil
+}
+
+// restoreManifest writes the backed up manifest of the browser back to its
+// original location. With keep unset, the backup is removed afterwards. It
+// reports whether a backup was restored.
+func restoreManifest(p *printer, changes *changeSet, b browser, fileName string, keep bool) (bool, error) {
+	backup, content, err := loadBackup(b, fileName)
+	if err != nil || backup == nil {
+		return false, err
+	}
+	p.Printf("Restoring the manifest of %s for %s: %s\n", backup.Target, b.displayName, backup.OriginalPath)
+	if err := changes.writeFile(backup.OriginalPath, content, 0644); err != nil {
+		return false, err
+	}
+	if keep {
+		return true, nil
+	}
+
+	contentPath, metaPath, err := backupPaths(b, fileName)
+	if err != nil {
+		return false, err
+	}
+	if err := changes.removeFile(contentPath); err != nil {
+		return false, err
+	}
+	return true, changes.removeFile(metaPath)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSwitchManifests(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+	t.Setenv("BW_BIO_CONFIG", filepath.Join(home, "config"))
+	chrome, _ := findBrowser("chrome")
+	fileName := defaultHostName + ".json"
+	path := chrome.manifestPath(home, fileName)
+	official := []byte(`{"name": "com.8bit.bitwarden", "path": "/opt/Bitwarden/desktop_proxy"}` + "\n")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, official, 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newPrinter(true)
+	switchTo := func(target string) {
+		t.Helper()
+		if err := switchManifests(p, &changeSet{p: p}, &manifestFlags{}, "chrome", target); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	switchTo("handler")
+	if owned, err := isHandlerManifest(path); err != nil || !owned {
+		t.Fatalf("isHandlerManifest() = %v, %v after switching to the handler", owned, err)
+	}
+	backup, content, err := loadBackup(chrome, fileName)
+	if err != nil || backup == nil {
+		t.Fatalf("loadBackup() = %v, %v", backup, err)
+	}
+	if string(content) != string(official) || backup.Browser != "chrome" || backup.OriginalPath != path || backup.HostName != defaultHostName || backup.Target != "/opt/Bitwarden/desktop_proxy" {
+		t.Errorf("loadBackup() = %+v, %q", backup, content)
+	}
+	// Opera reads Chrome's manifest, so it shares the backup.
+	opera, _ := findBrowser("opera")
+	if contentPath, _, _ := backupPaths(opera, fileName); contentPath != filepath.Join(home, ".local", "share", "bw-bio-handler", "backups", "chrome", fileName) {
+		t.Errorf("backupPaths(opera) = %s, want Chrome's backup", contentPath)
+	}
+
+	// Switching back keeps the backup, and switching again doesn't back up
+	// the handler's manifest.
+	switchTo("official")
+	if bs, err := os.ReadFile(path); err != nil || string(bs) != string(official) {
+		t.Fatalf("manifest after switching back = %q, %v", bs, err)
+	}
+	switchTo("handler")
+	switchTo("handler")
+	if _, content, err := loadBackup(chrome, fileName); err != nil || string(content) != string(official) {
+		t.Fatalf("backup after switching again = %q, %v", content, err)
+	}
+
+	if ok, err := restoreManifest(p, &changeSet{p: p}, chrome, fileName, false); err != nil || !ok {
+		t.Fatalf("restoreManifest() = %v, %v", ok, err)
+	}
+	if backup, _, err := loadBackup(chrome, fileName); err != nil || backup != nil {
+		t.Fatalf("loadBackup() = %v, %v after restoring, want no backup", backup, err)
+	}
+	if err := switchManifests(p, &changeSet{p: p}, &manifestFlags{}, "chrome", "official"); err == nil {
+		t.Fatal("switched to the official app without a backup")
+	}
+}
+
+func TestLoadBackupChecksum(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", home)
+	firefox, _ := findBrowser("firefox")
+	path := firefox.manifestPath(home, "host.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"path": "/usr/bin/other"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newPrinter(true)
+	if ok, err := backupManifest(p, &changeSet{p: p}, firefox, path, "host"); err != nil || !ok {
+		t.Fatalf("backupManifest() = %v, %v", ok, err)
+	}
+	contentPath, _, err := backupPaths(firefox, "host.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(contentPath, []byte(`{"path": "/tmp/evil"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadBackup(firefox, "host.json"); err == nil {
+		t.Fatal("loadBackup() accepted a modified backup")
+	}
+}
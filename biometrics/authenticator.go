@@ -0,0 +1,110 @@
+// Package biometrics asks the user to authorize releasing a key, through
+// the authentication methods of the platform.
+package biometrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrCanceled is returned by Options.Prompt when the user dismisses the
+// prompt.
+var ErrCanceled = errors.New("authentication canceled")
+
+// Result is the outcome of an authentication.
+type Result int
+
+const (
+	// Granted means the user authenticated successfully.
+	Granted Result = iota
+	// Denied means the user failed to authenticate, or isn't allowed to.
+	Denied
+	// Canceled means the user dismissed the prompt, or the context was
+	// canceled.
+	Canceled
+	// Unavailable means the authentication method can't be used, f.e.
+	// because its service isn't running.
+	Unavailable
+	// TimedOut means the context deadline passed before the user
+	// authenticated.
+	TimedOut
+)
+
+func (r Result) String() string {
+	switch r {
+	case Granted:
+		return "granted"
+	case Denied:
+		return "denied"
+	case Canceled:
+		return "canceled"
+	case Unavailable:
+		return "unavailable"
+	case TimedOut:
+		return "timed out"
+	default:
+		return fmt.Sprintf("Result(%d)", int(r))
+	}
+}
+
+// Request describes what the user is asked to authorize. Methods that can
+// show a message use it to tell the user why they are asked.
+type Request struct {
+	// Email is the account whose key is to be released.
+	Email string
+	// Browser is the name of the browser asking for the key.
+	Browser string
+	// Action is what the key is used for, f.e. "unlock".
+	Action string
+}
+
+// Reason returns a message describing the request.
+func (r Request) Reason() string {
+	reason := "Authenticate to " + r.Action + " Bitwarden"
+	if r.Email != "" {
+		reason += " for " + r.Email
+	}
+	if r.Browser != "" {
+		reason += " in " + r.Browser
+	}
+	return reason
+}
+
+// Authenticator asks the user to authorize a request. The error describes
+// why an authentication was Unavailable or failed otherwise, it is nil for
+// Granted and usually for Denied and Canceled.
+type Authenticator interface {
+	Authenticate(ctx context.Context, req Request) (Result, error)
+}
+
+// Options configure the Authenticator returned by NewAuthenticator.
+type Options struct {
+	// Method is the authentication method, or "" for the default of the
+	// platform.
+	Method string
+	// Feedback is told what the user has to do, f.e. to scan their finger
+	// again. It may be nil.
+	Feedback func(message string)
+	// Prompt asks the user for input, such as a password, explaining the
+	// reason for it. It is used by methods that can't show prompts
+	// themselves.
+	Prompt func(reason string, prompt string, echo bool) (string, error)
+	// PAMService is the PAM service used by the "pam" method.
+	PAMService string
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(ctx context.Context, req Request) (Result, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, req Request) (Result, error) {
+	return f(ctx, req)
+}
+
+// contextResult returns the result for a context that is done.
+func contextResult(ctx context.Context) Result {
+	if ctx.Err() == context.DeadlineExceeded {
+		return TimedOut
+	}
+	return Canceled
+}
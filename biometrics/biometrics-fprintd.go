@@ -0,0 +1,225 @@
+//go:build linux || freebsd || openbsd || netbsd || dragonfly
+
+package biometrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	fprintdName          = "net.reactivated.Fprint"
+	fprintdManagerPath   = "/net/reactivated/Fprint/Manager"
+	fprintdManagerIface  = "net.reactivated.Fprint.Manager"
+	fprintdDeviceIface   = "net.reactivated.Fprint.Device"
+	fprintdVerifyStatus  = fprintdDeviceIface + ".VerifyStatus"
+	defaultFprintdTries  = 3
+	fprintdCleanupPeriod = 5 * time.Second
+)
+
+// errNoFingerprint wraps the errors after which FprintdAuthenticator falls
+// back, as fingerprints can't be used at all.
+var errNoFingerprint = errors.New("no fingerprint available")
+
+// FprintdAuthenticator verifies a fingerprint with fprintd, independently of
+// how PAM is configured.
+type FprintdAuthenticator struct {
+	// Conn is the bus fprintd is on. The system bus is used if it is nil.
+	Conn *dbus.Conn
+	// MaxTries is the number of scans that may fail to match before the
+	// authentication is denied. Scans that couldn't be read, f.e. because
+	// the swipe was too short, are not counted. It defaults to 3.
+	MaxTries int
+	// Feedback is told what the user has to do, f.e. to scan again. It may
+	// be nil.
+	Feedback func(message string)
+	// Fallback is used when there is no fingerprint reader, no enrolled
+	// finger or fprintd isn't running. If it is nil, the authentication is
+	// Unavailable then.
+	Fallback Authenticator
+}
+
+// Authenticate claims the default fingerprint reader and verifies any
+// enrolled finger of the user.
+func (a *FprintdAuthenticator) Authenticate(ctx context.Context, req Request) (Result, error) {
+	result, err := a.verify(ctx, req)
+	if errors.Is(err, errNoFingerprint) && a.Fallback != nil {
+		return a.Fallback.Authenticate(ctx, req)
+	}
+	return result, err
+}
+
+func (a *FprintdAuthenticator) feedback(message string) {
+	if a.Feedback != nil {
+		a.Feedback(message)
+	}
+}
+
+func (a *FprintdAuthenticator) verify(ctx context.Context, req Request) (Result, error) {
+	conn := a.Conn
+	if conn == nil {
+		var err error
+		if conn, err = dbus.SystemBus(); err != nil {
+			return Unavailable, fmt.Errorf("%w: could not connect to the system bus: %v", errNoFingerprint, err)
+		}
+	}
+
+	var path dbus.ObjectPath
+	err := conn.Object(fprintdName, fprintdManagerPath).
+		CallWithContext(ctx, fprintdManagerIface+".GetDefaultDevice", 0).
+		Store(&path)
+	if err != nil {
+		return Unavailable, fprintdError(err)
+	}
+	device := conn.Object(fprintdName, path)
+	var fingers []string
+	if err := device.CallWithContext(ctx, fprintdDeviceIface+".ListEnrolledFingers", 0, "").Store(&fingers); err != nil {
+		return Unavailable, fprintdError(err)
+	}
+	if len(fingers) == 0 {
+		return Unavailable, fmt.Errorf("%w: no enrolled fingers", errNoFingerprint)
+	}
+
+	// Subscribe before claiming, so that no status is missed.
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface(fprintdDeviceIface),
+		dbus.WithMatchMember("VerifyStatus"),
+	}
+	if err := conn.AddMatchSignal(match...); err != nil {
+		return Unavailable, err
+	}
+	defer conn.RemoveMatchSignal(match...)
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+
+	if err := device.CallWithContext(ctx, fprintdDeviceIface+".Claim", 0, "").Err; err != nil {
+		return Unavailable, fprintdError(err)
+	}
+	defer cleanup(device, "Release")
+
+	swipe := false
+	if scanType, err := device.GetProperty(fprintdDeviceIface + ".scan-type"); err == nil {
+		swipe = scanType.Value() == "swipe"
+	}
+	if swipe {
+		a.feedback(req.Reason() + ": swipe your finger across the fingerprint reader.")
+	} else {
+		a.feedback(req.Reason() + ": place your finger on the fingerprint reader.")
+	}
+
+	maxTries := a.MaxTries
+	if maxTries <= 0 {
+		maxTries = defaultFprintdTries
+	}
+	for tries := 0; tries < maxTries; {
+		if err := device.CallWithContext(ctx, fprintdDeviceIface+".VerifyStart", 0, "any").Err; err != nil {
+			return Unavailable, fprintdError(err)
+		}
+		status, err := waitVerifyStatus(ctx, signals, path, a.feedback, swipe)
+		cleanup(device, "VerifyStop")
+		if err != nil {
+			return Unavailable, err
+		}
+		switch status {
+		case "":
+			return contextResult(ctx), nil
+		case "verify-match":
+			return Granted, nil
+		case "verify-no-match":
+			tries++
+			if tries < maxTries {
+				a.feedback("The fingerprint didn't match, try again.")
+			}
+		}
+		// Statuses asking to scan again end the verification on some
+		// devices, it is restarted then.
+	}
+	return Denied, nil
+}
+
+// waitVerifyStatus waits for the VerifyStatus signal ending a verification
+// and returns its status, or "" when ctx is done first. The statuses of
+// scans that couldn't be read are reported to feedback.
+func waitVerifyStatus(ctx context.Context, signals <-chan *dbus.Signal, path dbus.ObjectPath, feedback func(string), swipe bool) (string, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return "", nil
+		case sig, ok := <-signals:
+			if !ok {
+				return "", errors.New("fprintd connection closed")
+			}
+			if sig.Path != path || sig.Name != fprintdVerifyStatus || len(sig.Body) != 2 {
+				continue
+			}
+			status, _ := sig.Body[0].(string)
+			done, _ := sig.Body[1].(bool)
+			switch status {
+			case "verify-match", "verify-no-match":
+				return status, nil
+			case "verify-disconnected":
+				return "", errors.New("the fingerprint reader was disconnected")
+			case "verify-unknown-error":
+				return "", errors.New("the fingerprint reader failed")
+			}
+			if message := retryMessage(status, swipe); message != "" {
+				feedback(message)
+			}
+			if done {
+				return status, nil
+			}
+		}
+	}
+}
+
+// retryMessage tells the user how to retry after a scan that couldn't be
+// read, like pam_fprintd does.
+func retryMessage(status string, swipe bool) string {
+	switch status {
+	case "verify-retry-scan":
+		if swipe {
+			return "Swipe your finger again."
+		}
+		return "Place your finger on the reader again."
+	case "verify-swipe-too-short":
+		return "The swipe was too short, try again."
+	case "verify-finger-not-centered":
+		return "Your finger was not centered, try again."
+	case "verify-remove-and-retry":
+		return "Remove your finger, and try again."
+	}
+	return ""
+}
+
+// cleanup calls a method of the device that has to run even when the
+// context of the authentication is done.
+func cleanup(device dbus.BusObject, method string) {
+	ctx, cancel := context.WithTimeout(context.Background(), fprintdCleanupPeriod)
+	defer cancel()
+	_ = device.CallWithContext(ctx, fprintdDeviceIface+"."+method, 0).Err
+}
+
+// fprintdError describes an error of fprintd, wrapping errNoFingerprint if
+// fingerprints can't be used at all.
+func fprintdError(err error) error {
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) {
+		return fmt.Errorf("fprintd: %v", err)
+	}
+	switch dbusErr.Name {
+	case "net.reactivated.Fprint.Error.NoSuchDevice",
+		"net.reactivated.Fprint.Error.NoEnrolledPrints",
+		"org.freedesktop.DBus.Error.ServiceUnknown",
+		"org.freedesktop.DBus.Error.NameHasNoOwner":
+		return fmt.Errorf("%w: %v", errNoFingerprint, err)
+	case "net.reactivated.Fprint.Error.AlreadyInUse":
+		return errors.New("the fingerprint reader is in use")
+	}
+	return fmt.Errorf("fprintd: %v", err)
+}
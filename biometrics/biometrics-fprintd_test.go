@@ -0,0 +1,244 @@
+//go:build linux || freebsd || openbsd || netbsd || dragonfly
+
+package biometrics_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/quexten/bw-bio-handler/biometrics"
+	"github.com/quexten/bw-bio-handler/internal/dbustest"
+)
+
+const mockDevicePath = dbus.ObjectPath("/net/reactivated/Fprint/Device/0")
+
+func connect(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+type verifyStatus struct {
+	status string
+	done   bool
+}
+
+// mockFprintd implements the parts of fprintd used by the authenticator.
+// Every VerifyStart emits the next script of statuses.
+type mockFprintd struct {
+	conn      *dbus.Conn
+	noDevice  bool
+	fingers   []string
+	scanType  string
+	scripts   [][]verifyStatus
+	mu        sync.Mutex
+	claimed   bool
+	released  bool
+	verifying bool
+	starts    int
+}
+
+func (m *mockFprintd) GetDefaultDevice() (dbus.ObjectPath, *dbus.Error) {
+	if m.noDevice {
+		return "", dbus.NewError("net.reactivated.Fprint.Error.NoSuchDevice", []interface{}{"No devices available"})
+	}
+	return mockDevicePath, nil
+}
+
+func (m *mockFprintd) ListEnrolledFingers(username string) ([]string, *dbus.Error) {
+	if len(m.fingers) == 0 {
+		return nil, dbus.NewError("net.reactivated.Fprint.Error.NoEnrolledPrints", []interface{}{"Failed to discover prints"})
+	}
+	return m.fingers, nil
+}
+
+func (m *mockFprintd) Claim(username string) *dbus.Error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.claimed {
+		return dbus.NewError("net.reactivated.Fprint.Error.AlreadyInUse", []interface{}{"Device was already claimed"})
+	}
+	m.claimed = true
+	return nil
+}
+
+func (m *mockFprintd) Release() *dbus.Error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claimed = false
+	m.released = true
+	return nil
+}
+
+func (m *mockFprintd) VerifyStart(finger string) *dbus.Error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.claimed || m.verifying {
+		return dbus.NewError("net.reactivated.Fprint.Error.ClaimDevice", []interface{}{"Device was not claimed"})
+	}
+	m.verifying = true
+	var script []verifyStatus
+	if m.starts < len(m.scripts) {
+		script = m.scripts[m.starts]
+	}
+	m.starts++
+	go func() {
+		for _, s := range script {
+			m.conn.Emit(mockDevicePath, "net.reactivated.Fprint.Device.VerifyStatus", s.status, s.done)
+		}
+	}()
+	return nil
+}
+
+func (m *mockFprintd) VerifyStop() *dbus.Error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.verifying = false
+	return nil
+}
+
+// Get implements org.freedesktop.DBus.Properties for the scan-type.
+func (m *mockFprintd) Get(iface string, property string) (dbus.Variant, *dbus.Error) {
+	if iface != "net.reactivated.Fprint.Device" || property != "scan-type" {
+		return dbus.Variant{}, dbus.MakeFailedError(os.ErrNotExist)
+	}
+	return dbus.MakeVariant(m.scanType), nil
+}
+
+// startFprintd exports m on a private bus and returns a connection of a
+// client to the bus.
+func startFprintd(t *testing.T, m *mockFprintd) *dbus.Conn {
+	t.Helper()
+	address := dbustest.PrivateBus(t)
+	m.conn = connect(t, address)
+	if m.scanType == "" {
+		m.scanType = "press"
+	}
+	for _, export := range []struct {
+		path  dbus.ObjectPath
+		iface string
+	}{
+		{"/net/reactivated/Fprint/Manager", "net.reactivated.Fprint.Manager"},
+		{mockDevicePath, "net.reactivated.Fprint.Device"},
+		{mockDevicePath, "org.freedesktop.DBus.Properties"},
+	} {
+		if err := m.conn.Export(m, export.path, export.iface); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.conn.RequestName("net.reactivated.Fprint", dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatal(err)
+	}
+	return connect(t, address)
+}
+
+func TestFprintdAuthenticator(t *testing.T) {
+	fallback := biometrics.AuthenticatorFunc(func(ctx context.Context, req biometrics.Request) (biometrics.Result, error) {
+		return biometrics.Canceled, nil
+	})
+	noMatch := []verifyStatus{{"verify-no-match", true}}
+	tests := []struct {
+		name     string
+		mock     *mockFprintd
+		want     biometrics.Result
+		starts   int
+		feedback []string
+	}{
+		{
+			name:   "match",
+			mock:   &mockFprintd{fingers: []string{"right-index-finger"}, scripts: [][]verifyStatus{{{"verify-match", true}}}},
+			want:   biometrics.Granted,
+			starts: 1,
+			feedback: []string{
+				"Authenticate to unlock Bitwarden for me@example.com in firefox: place your finger on the fingerprint reader.",
+			},
+		},
+		{
+			name:   "retry",
+			mock:   &mockFprintd{fingers: []string{"right-index-finger"}, scanType: "swipe", scripts: [][]verifyStatus{{{"verify-swipe-too-short", false}, {"verify-retry-scan", true}}, noMatch, {{"verify-match", true}}}},
+			want:   biometrics.Granted,
+			starts: 3,
+			feedback: []string{
+				"Authenticate to unlock Bitwarden for me@example.com in firefox: swipe your finger across the fingerprint reader.",
+				"The swipe was too short, try again.",
+				"Swipe your finger again.",
+				"The fingerprint didn't match, try again.",
+			},
+		},
+		{
+			name:   "no match",
+			mock:   &mockFprintd{fingers: []string{"right-index-finger"}, scripts: [][]verifyStatus{noMatch, noMatch, noMatch, {{"verify-match", true}}}},
+			want:   biometrics.Denied,
+			starts: 3,
+		},
+		{
+			name: "no enrolled fingers",
+			mock: &mockFprintd{},
+			want: biometrics.Canceled,
+		},
+		{
+			name: "no device",
+			mock: &mockFprintd{noDevice: true},
+			want: biometrics.Canceled,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var feedback []string
+			auth := &biometrics.FprintdAuthenticator{
+				Conn:     startFprintd(t, test.mock),
+				Feedback: func(message string) { feedback = append(feedback, message) },
+				Fallback: fallback,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			result, err := auth.Authenticate(ctx, biometrics.Request{Email: "me@example.com", Browser: "firefox", Action: "unlock"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != test.want {
+				t.Errorf("got %s, want %s", result, test.want)
+			}
+
+			test.mock.mu.Lock()
+			defer test.mock.mu.Unlock()
+			if test.mock.starts != test.starts {
+				t.Errorf("VerifyStart called %d times, want %d", test.mock.starts, test.starts)
+			}
+			if test.starts > 0 && (test.mock.claimed || !test.mock.released || test.mock.verifying) {
+				t.Errorf("device left claimed %v, released %v, verifying %v", test.mock.claimed, test.mock.released, test.mock.verifying)
+			}
+			if test.feedback != nil && strings.Join(feedback, "\n") != strings.Join(test.feedback, "\n") {
+				t.Errorf("got feedback %q, want %q", feedback, test.feedback)
+			}
+		})
+	}
+}
+
+func TestFprintdAuthenticatorTimeout(t *testing.T) {
+	mock := &mockFprintd{fingers: []string{"right-index-finger"}}
+	auth := &biometrics.FprintdAuthenticator{Conn: startFprintd(t, mock)}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	result, err := auth.Authenticate(ctx, biometrics.Request{Action: "unlock"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != biometrics.TimedOut {
+		t.Errorf("got %s, want %s", result, biometrics.TimedOut)
+	}
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+	if mock.claimed || mock.verifying {
+		t.Errorf("device left claimed %v, verifying %v", mock.claimed, mock.verifying)
+	}
+}
@@ -3,10 +3,50 @@
 package biometrics
 
 import (
+	"context"
+	"fmt"
+
 	touchid "github.com/lox/go-touchid"
 )
 
-func CheckBiometrics() bool {
-	ok, err := touchid.Authenticate("Unlock Bitwarden browser extension")
-	return err != nil && ok
+// TouchIDAuthenticator asks for Touch ID, or the account password when
+// Touch ID isn't set up.
+type TouchIDAuthenticator struct{}
+
+// NewAuthenticator returns the Authenticator for the method of opts, which
+// can only be "touchid". Touch ID shows its own prompt, so the feedback and
+// prompt of opts are not used.
+func NewAuthenticator(opts Options) (Authenticator, error) {
+	if opts.Method != "" && opts.Method != "touchid" {
+		return nil, fmt.Errorf("unknown authentication method %q, use touchid", opts.Method)
+	}
+	return TouchIDAuthenticator{}, nil
+}
+
+// Authenticate shows the Touch ID prompt with the reason of the request.
+// The prompt can't be dismissed from here, so when ctx is done first its
+// result is ignored.
+func (TouchIDAuthenticator) Authenticate(ctx context.Context, req Request) (Result, error) {
+	type check struct {
+		ok  bool
+		err error
+	}
+	done := make(chan check, 1)
+	go func() {
+		ok, err := touchid.Authenticate(req.Reason())
+		done <- check{ok, err}
+	}()
+
+	select {
+	case c := <-done:
+		if c.err != nil {
+			return Unavailable, fmt.Errorf("touch id: %v", c.err)
+		}
+		if !c.ok {
+			return Denied, nil
+		}
+		return Granted, nil
+	case <-ctx.Done():
+		return contextResult(ctx), nil
+	}
 }
@@ -0,0 +1,9 @@
+//go:build (linux || freebsd || openbsd || netbsd || dragonfly) && !pam
+
+package biometrics
+
+import "errors"
+
+func newPAMAuthenticator(opts Options) (Authenticator, error) {
+	return nil, errors.New("pam is not supported by this build, rebuild it with -tags pam")
+}
@@ -0,0 +1,213 @@
+//go:build (linux || freebsd || openbsd || netbsd || dragonfly) && pam
+
+package biometrics
+
+/*
+#cgo LDFLAGS: -lpam
+#include <security/pam_appl.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+extern int goConversation(uintptr_t handle, int num_msg, struct pam_message **msg, struct pam_response **resp);
+
+static int conversation(int num_msg, const struct pam_message **msg, struct pam_response **resp, void *appdata_ptr) {
+	return goConversation((uintptr_t)appdata_ptr, num_msg, (struct pam_message **)msg, resp);
+}
+
+static int start(const char *service, const char *user, uintptr_t handle, pam_handle_t **pamh) {
+	struct pam_conv conv = { conversation, (void *)handle };
+	return pam_start(service, user, &conv, pamh);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/user"
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+)
+
+// PAMAuthenticator authenticates through a PAM service, showing its prompts
+// through Prompt and its messages through Feedback. It works without a
+// polkit agent, f.e. in tiling window managers.
+type PAMAuthenticator struct {
+	// Service is the PAM service, f.e. "bw-bio-handler".
+	Service string
+	// User is the user to authenticate, the current user if it is empty.
+	User string
+	// Prompt asks for the answers to the questions of the service, such as
+	// the password. It returns ErrCanceled when the user dismisses it.
+	Prompt func(reason string, prompt string, echo bool) (string, error)
+	// Feedback shows the messages of the service, f.e. to place a finger on
+	// the reader. It may be nil.
+	Feedback func(message string)
+}
+
+func newPAMAuthenticator(opts Options) (Authenticator, error) {
+	if opts.Prompt == nil {
+		return nil, errors.New("pam needs a prompt")
+	}
+	return &PAMAuthenticator{
+		Service:  opts.PAMService,
+		Prompt:   opts.Prompt,
+		Feedback: opts.Feedback,
+	}, nil
+}
+
+// pamConversation answers the questions of a PAM transaction for a request.
+type pamConversation struct {
+	auth   *PAMAuthenticator
+	reason string
+
+	mu sync.Mutex
+	// done is set when the authentication has returned, so that a late
+	// question isn't prompted anymore.
+	done     bool
+	canceled bool
+	err      error
+}
+
+func (c *pamConversation) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done = true
+}
+
+// answer answers a single message, and reports whether the conversation
+// may go on.
+func (c *pamConversation) answer(style C.int, message string) (string, bool) {
+	c.mu.Lock()
+	done := c.done
+	c.mu.Unlock()
+	if done {
+		return "", false
+	}
+
+	switch style {
+	case C.PAM_PROMPT_ECHO_OFF, C.PAM_PROMPT_ECHO_ON:
+		answer, err := c.auth.Prompt(c.reason, message, style == C.PAM_PROMPT_ECHO_ON)
+		if err != nil {
+			c.mu.Lock()
+			c.canceled = errors.Is(err, ErrCanceled)
+			if !c.canceled {
+				c.err = err
+			}
+			c.mu.Unlock()
+			return "", false
+		}
+		return answer, true
+	case C.PAM_ERROR_MSG, C.PAM_TEXT_INFO:
+		if c.auth.Feedback != nil {
+			c.auth.Feedback(message)
+		}
+		return "", true
+	}
+	return "", false
+}
+
+//export goConversation
+func goConversation(handle C.uintptr_t, n C.int, msgs **C.struct_pam_message, resp **C.struct_pam_response) C.int {
+	c := cgo.Handle(handle).Value().(*pamConversation)
+	if n <= 0 {
+		return C.PAM_CONV_ERR
+	}
+	messages := unsafe.Slice(msgs, int(n))
+	responses := (*C.struct_pam_response)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof(C.struct_pam_response{}))))
+	answers := unsafe.Slice(responses, int(n))
+	for i, msg := range messages {
+		answer, ok := c.answer(msg.msg_style, C.GoString(msg.msg))
+		if !ok {
+			for _, a := range answers[:i] {
+				C.free(unsafe.Pointer(a.resp))
+			}
+			C.free(unsafe.Pointer(responses))
+			return C.PAM_CONV_ERR
+		}
+		// Prompts are always answered, with an empty string if need be, as
+		// modules may not expect a NULL response.
+		if msg.msg_style == C.PAM_PROMPT_ECHO_OFF || msg.msg_style == C.PAM_PROMPT_ECHO_ON {
+			answers[i].resp = C.CString(answer)
+		}
+	}
+	*resp = responses
+	return C.PAM_SUCCESS
+}
+
+// Authenticate runs the authentication and account management of the
+// service. PAM transactions can't be interrupted or ended from another
+// thread, so when ctx is done first the remaining questions are answered
+// with an error and the result is ignored. The goroutine running the
+// transaction is left behind until PAM returns, f.e. when pam_fprintd gives
+// up waiting for a finger, and only then ends the transaction.
+func (a *PAMAuthenticator) Authenticate(ctx context.Context, req Request) (Result, error) {
+	name := a.User
+	if name == "" {
+		u, err := user.Current()
+		if err != nil {
+			return Unavailable, err
+		}
+		name = u.Username
+	}
+
+	c := &pamConversation{auth: a, reason: req.Reason()}
+	type check struct {
+		result Result
+		err    error
+	}
+	done := make(chan check, 1)
+	go func() {
+		result, err := a.authenticate(c, name)
+		done <- check{result, err}
+	}()
+
+	select {
+	case check := <-done:
+		return check.result, check.err
+	case <-ctx.Done():
+		c.stop()
+		return contextResult(ctx), nil
+	}
+}
+
+func (a *PAMAuthenticator) authenticate(c *pamConversation, name string) (Result, error) {
+	handle := cgo.NewHandle(c)
+	defer handle.Delete()
+	service := C.CString(a.Service)
+	defer C.free(unsafe.Pointer(service))
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	var pamh *C.pam_handle_t
+	rc := C.start(service, cname, C.uintptr_t(handle), &pamh)
+	if rc != C.PAM_SUCCESS {
+		return Unavailable, fmt.Errorf("could not start pam service %s: %s", a.Service, C.GoString(C.pam_strerror(nil, rc)))
+	}
+	defer func() { C.pam_end(pamh, rc) }()
+
+	rc = C.pam_authenticate(pamh, 0)
+	if rc == C.PAM_SUCCESS {
+		rc = C.pam_acct_mgmt(pamh, 0)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case rc == C.PAM_SUCCESS:
+		return Granted, nil
+	case c.canceled:
+		return Canceled, nil
+	case c.err != nil:
+		return Unavailable, fmt.Errorf("pam prompt failed: %v", c.err)
+	}
+	switch rc {
+	case C.PAM_AUTH_ERR, C.PAM_MAXTRIES, C.PAM_USER_UNKNOWN, C.PAM_PERM_DENIED,
+		C.PAM_ACCT_EXPIRED, C.PAM_NEW_AUTHTOK_REQD:
+		return Denied, nil
+	default:
+		return Unavailable, fmt.Errorf("pam: %s", C.GoString(C.pam_strerror(pamh, rc)))
+	}
+}
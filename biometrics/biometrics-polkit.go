@@ -2,23 +2,93 @@
 
 package biometrics
 
-import "github.com/amenzhinsky/go-polkit"
+import (
+	"context"
+	"errors"
+	"fmt"
 
-func CheckBiometrics() bool {
+	"github.com/amenzhinsky/go-polkit"
+	"github.com/godbus/dbus/v5"
+	"github.com/google/uuid"
+)
+
+// PolkitActionID is the polkit action authorizing an unlock, defined by the
+// policy installed by bw-bio-handler.
+const PolkitActionID = "com.quexten.bw-bio-handler.unlock"
+
+// PolkitAuthenticator asks the polkit authentication agent of the session,
+// which authenticates with whatever the PAM stack of polkit allows, such as
+// the password or a fingerprint.
+type PolkitAuthenticator struct {
+	ActionID string
+}
+
+// NewAuthenticator returns the Authenticator for the method of opts:
+// "fprintd", "polkit" or "pam". The default verifies a fingerprint with
+// fprintd, and falls back to polkit when no fingerprint can be used.
+func NewAuthenticator(opts Options) (Authenticator, error) {
+	polkit := &PolkitAuthenticator{ActionID: PolkitActionID}
+	switch opts.Method {
+	case "":
+		return &FprintdAuthenticator{Feedback: opts.Feedback, Fallback: polkit}, nil
+	case "fprintd":
+		return &FprintdAuthenticator{Feedback: opts.Feedback}, nil
+	case "polkit":
+		return polkit, nil
+	case "pam":
+		return newPAMAuthenticator(opts)
+	default:
+		return nil, fmt.Errorf("unknown authentication method %q, use fprintd, polkit or pam", opts.Method)
+	}
+}
+
+// Authenticate checks the authorization of the action, allowing the agent
+// to prompt with the message of the policy. The request isn't passed on, as
+// polkitd only accepts details from root or the owner of the action.
+// Canceling ctx cancels the check and dismisses the prompt.
+func (a *PolkitAuthenticator) Authenticate(ctx context.Context, req Request) (Result, error) {
 	authority, err := polkit.NewAuthority()
 	if err != nil {
-		return false
+		return Unavailable, fmt.Errorf("could not connect to polkit: %v", err)
 	}
+	defer authority.Close()
 
-	result, err := authority.CheckAuthorization(
-		"com.quexten.bw-bio-handler.unlock",
-		nil,
-		polkit.CheckAuthorizationAllowUserInteraction, "",
-	)
+	cancellationID := uuid.NewString()
+	type check struct {
+		result *polkit.PKAuthorizationResult
+		err    error
+	}
+	done := make(chan check, 1)
+	go func() {
+		result, err := authority.CheckAuthorization(a.ActionID, nil, polkit.CheckAuthorizationAllowUserInteraction, cancellationID)
+		done <- check{result, err}
+	}()
 
-	if err != nil {
-		return false
+	var c check
+	select {
+	case c = <-done:
+	case <-ctx.Done():
+		_ = authority.CancelCheckAuthorization(cancellationID)
+		<-done
+		return contextResult(ctx), nil
 	}
 
-	return result.IsAuthorized
+	if c.err != nil {
+		var dbusErr dbus.Error
+		if errors.As(c.err, &dbusErr) && dbusErr.Name == "org.freedesktop.PolicyKit1.Error.Cancelled" {
+			return Canceled, nil
+		}
+		return Unavailable, fmt.Errorf("polkit authorization check failed: %v", c.err)
+	}
+	switch {
+	case c.result.IsAuthorized:
+		return Granted, nil
+	case c.result.Details["polkit.dismissed"] != "":
+		return Canceled, nil
+	case c.result.IsChallenge:
+		// Authentication would be possible, but no agent could ask.
+		return Unavailable, errors.New("no polkit authentication agent is running")
+	default:
+		return Denied, nil
+	}
 }
@@ -2,7 +2,23 @@
 
 package biometrics
 
-func CheckBiometrics() bool {
-	panic("Not implemented on Windows")
-	return false
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type unsupportedAuthenticator struct{}
+
+// NewAuthenticator returns the default Authenticator of the platform.
+// Windows Hello is not supported yet, so authentication is unavailable.
+func NewAuthenticator(opts Options) (Authenticator, error) {
+	if opts.Method != "" {
+		return nil, fmt.Errorf("unknown authentication method %q", opts.Method)
+	}
+	return unsupportedAuthenticator{}, nil
+}
+
+func (unsupportedAuthenticator) Authenticate(ctx context.Context, req Request) (Result, error) {
+	return Unavailable, errors.New("authentication is not implemented on Windows")
 }
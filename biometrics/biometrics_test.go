@@ -1,14 +1,34 @@
 package biometrics_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/quexten/bw-bio-handler/biometrics"
 )
 
 func TestUnlock(t *testing.T) {
-	authorization := biometrics.CheckBiometrics()
-	if !authorization {
-		t.Fatalf("Authorization failed")
+	auth, err := biometrics.NewAuthenticator(biometrics.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := auth.Authenticate(context.Background(), biometrics.Request{Action: "unlock"})
+	if result != biometrics.Granted {
+		t.Fatalf("Authorization failed: %s, %v", result, err)
+	}
+}
+
+func TestReason(t *testing.T) {
+	tests := []struct {
+		req  biometrics.Request
+		want string
+	}{
+		{biometrics.Request{Action: "unlock"}, "Authenticate to unlock Bitwarden"},
+		{biometrics.Request{Email: "me@example.com", Browser: "firefox", Action: "unlock"}, "Authenticate to unlock Bitwarden for me@example.com in firefox"},
+	}
+	for _, test := range tests {
+		if got := test.req.Reason(); got != test.want {
+			t.Errorf("Reason() = %q, want %q", got, test.want)
+		}
 	}
 }
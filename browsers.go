@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// manifestFlavour is the dialect of native messaging manifest a browser
+// understands.
+type manifestFlavour int
+
+const (
+	flavourChrome manifestFlavour = iota
+	flavourMozilla
+)
+
+// browser describes where a supported browser looks for native messaging
+// manifests. All paths are relative to the home directory.
+type browser struct {
+	name        string
+	displayName string
+	flavour     manifestFlavour
+	// profileDir exists once the browser has been started at least once.
+	profileDir  string
+	manifestDir string
+	executables []string
+}
+
+var browsers = []browser{
+	{
+		name:        "chrome",
+		displayName: "Google Chrome",
+		flavour:     flavourChrome,
+		profileDir:  ".config/google-chrome",
+		manifestDir: ".config/google-chrome/NativeMessagingHosts",
+		executables: []string{"google-chrome", "google-chrome-stable"},
+	},
+	{
+		name:        "chromium",
+		displayName: "Chromium",
+		flavour:     flavourChrome,
+		profileDir:  ".config/chromium",
+		manifestDir: ".config/chromium/NativeMessagingHosts",
+		executables: []string{"chromium", "chromium-browser"},
+	},
+	{
+		name:        "brave",
+		displayName: "Brave",
+		flavour:     flavourChrome,
+		profileDir:  ".config/BraveSoftware/Brave-Browser",
+		manifestDir: ".config/BraveSoftware/Brave-Browser/NativeMessagingHosts",
+		executables: []string{"brave", "brave-browser"},
+	},
+	{
+		name:        "vivaldi",
+		displayName: "Vivaldi",
+		flavour:     flavourChrome,
+		profileDir:  ".config/vivaldi",
+		manifestDir: ".config/vivaldi/NativeMessagingHosts",
+		executables: []string{"vivaldi", "vivaldi-stable"},
+	},
+	{
+		name:        "edge",
+		displayName: "Microsoft Edge",
+		flavour:     flavourChrome,
+		profileDir:  ".config/microsoft-edge",
+		manifestDir: ".config/microsoft-edge/NativeMessagingHosts",
+		executables: []string{"microsoft-edge", "microsoft-edge-stable"},
+	},
+	{
+		// Opera on Linux reads the manifests from Chrome's directory.
+		name:        "opera",
+		displayName: "Opera",
+		flavour:     flavourChrome,
+		profileDir:  ".config/opera",
+		manifestDir: ".config/google-chrome/NativeMessagingHosts",
+		executables: []string{"opera"},
+	},
+	{
+		name:        "firefox",
+		displayName: "Firefox",
+		flavour:     flavourMozilla,
+		profileDir:  ".mozilla/firefox",
+		manifestDir: ".mozilla/native-messaging-hosts",
+		executables: []string{"firefox", "firefox-esr"},
+	},
+	{
+		name:        "librewolf",
+		displayName: "LibreWolf",
+		flavour:     flavourMozilla,
+		profileDir:  ".librewolf",
+		manifestDir: ".librewolf/native-messaging-hosts",
+		executables: []string{"librewolf"},
+	},
+	{
+		name:        "waterfox",
+		displayName: "Waterfox",
+		flavour:     flavourMozilla,
+		profileDir:  ".waterfox",
+		manifestDir: ".waterfox/native-messaging-hosts",
+		executables: []string{"waterfox"},
+	},
+	{
+		name:        "floorp",
+		displayName: "Floorp",
+		flavour:     flavourMozilla,
+		profileDir:  ".floorp",
+		manifestDir: ".floorp/native-messaging-hosts",
+		executables: []string{"floorp"},
+	},
+}
+
+func browserNames() []string {
+	names := make([]string, len(browsers))
+	for i, b := range browsers {
+		names[i] = b.name
+	}
+	return names
+}
+
+func findBrowser(name string) (browser, bool) {
+	for _, b := range browsers {
+		if b.name == name {
+			return b, true
+		}
+	}
+	return browser{}, false
+}
+
+// installed reports whether the browser has a profile directory or an
+// executable in $PATH.
+func (b browser) installed(home string) bool {
+	if _, err := os.Stat(filepath.Join(home, b.profileDir)); err == nil {
+		return true
+	}
+	for _, executable := range b.executables {
+		if _, err := exec.LookPath(executable); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (b browser) manifestPath(home string, fileName string) string {
+	return filepath.Join(home, b.manifestDir, fileName)
+}
+
+// selectBrowsers resolves the comma separated --browser value. An empty
+// selection picks every installed browser, "all" picks every known one.
+func selectBrowsers(selection string, home string) ([]browser, error) {
+	switch selection {
+	case "":
+		var selected []browser
+		for _, b := range browsers {
+			if b.installed(home) {
+				selected = append(selected, b)
+			}
+		}
+		return selected, nil
+	case "all":
+		return browsers, nil
+	}
+
+	var selected []browser
+	for _, name := range strings.Split(selection, ",") {
+		b, ok := findBrowser(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown browser %q, known browsers are: %s", name, strings.Join(browserNames(), ", "))
+		}
+		selected = append(selected, b)
+	}
+	return selected, nil
+}
+
+// processNames maps the executables browsers run as, where they differ from
+// the commands that start them, to the browser names.
+var processNames = map[string]string{
+	"chrome":      "chrome",
+	"firefox-bin": "firefox",
+	"msedge":      "edge",
+	"vivaldi-bin": "vivaldi",
+}
+
+// detectBrowser guesses the name of the browser that started the handler.
+// Mozilla browsers pass the path of the manifest, which is in a directory of
+// their own; otherwise the executable of the parent process is looked up.
+func detectBrowser() string {
+	home := os.Getenv("HOME")
+	for _, arg := range os.Args[1:] {
+		for _, b := range browsers {
+			if b.flavour == flavourMozilla && strings.HasPrefix(arg, filepath.Join(home, b.manifestDir)+string(filepath.Separator)) {
+				return b.name
+			}
+		}
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", os.Getppid()))
+	if err != nil {
+		return "unknown"
+	}
+	return browserForExecutable(filepath.Base(exe))
+}
+
+// browserForExecutable returns the name of the browser running as the
+// executable, or the executable if it isn't a known browser.
+func browserForExecutable(name string) string {
+	if browser, ok := processNames[name]; ok {
+		return browser
+	}
+	for _, b := range browsers {
+		if b.name == name {
+			return b.name
+		}
+		for _, executable := range b.executables {
+			if executable == name {
+				return b.name
+			}
+		}
+	}
+	return name
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestManifestPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		flavour manifestFlavour
+		path    string
+	}{
+		{"chrome", flavourChrome, "/home/user/.config/google-chrome/NativeMessagingHosts/com.8bit.bitwarden.json"},
+		{"chromium", flavourChrome, "/home/user/.config/chromium/NativeMessagingHosts/com.8bit.bitwarden.json"},
+		{"brave", flavourChrome, "/home/user/.config/BraveSoftware/Brave-Browser/NativeMessagingHosts/com.8bit.bitwarden.json"},
+		{"vivaldi", flavourChrome, "/home/user/.config/vivaldi/NativeMessagingHosts/com.8bit.bitwarden.json"},
+		{"edge", flavourChrome, "/home/user/.config/microsoft-edge/NativeMessagingHosts/com.8bit.bitwarden.json"},
+		// Opera shares Chrome's manifest.
+		{"opera", flavourChrome, "/home/user/.config/google-chrome/NativeMessagingHosts/com.8bit.bitwarden.json"},
+		{"firefox", flavourMozilla, "/home/user/.mozilla/native-messaging-hosts/com.8bit.bitwarden.json"},
+		{"librewolf", flavourMozilla, "/home/user/.librewolf/native-messaging-hosts/com.8bit.bitwarden.json"},
+		{"waterfox", flavourMozilla, "/home/user/.waterfox/native-messaging-hosts/com.8bit.bitwarden.json"},
+		{"floorp", flavourMozilla, "/home/user/.floorp/native-messaging-hosts/com.8bit.bitwarden.json"},
+	}
+	if len(tests) != len(browsers) {
+		t.Errorf("%d browsers are known, %d tested", len(browsers), len(tests))
+	}
+	for _, test := range tests {
+		b, ok := findBrowser(test.name)
+		if !ok {
+			t.Errorf("%s: unknown browser", test.name)
+			continue
+		}
+		if b.flavour != test.flavour {
+			t.Errorf("%s: flavour %d, want %d", test.name, b.flavour, test.flavour)
+		}
+		if got := b.manifestPath("/home/user", "com.8bit.bitwarden.json"); got != test.path {
+			t.Errorf("%s: manifestPath() = %q, want %q", test.name, got, test.path)
+		}
+	}
+}
+
+func TestSelectBrowsers(t *testing.T) {
+	selected, err := selectBrowsers("firefox, opera", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 2 || selected[0].name != "firefox" || selected[1].name != "opera" {
+		t.Errorf("selectBrowsers() = %v, want firefox and opera", selected)
+	}
+	if selected, err := selectBrowsers("all", t.TempDir()); err != nil || len(selected) != len(browsers) {
+		t.Errorf("selectBrowsers(all) = %d browsers, %v, want all %d", len(selected), err, len(browsers))
+	}
+	if _, err := selectBrowsers("netscape", t.TempDir()); err == nil {
+		t.Error("selectBrowsers() selected an unknown browser")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+// change is a single modification of the system made by install or
+// uninstall.
+type change struct {
+	Action  string   `json:"action"`
+	Path    string   `json:"path,omitempty"`
+	Command []string `json:"command,omitempty"`
+	UserID  string   `json:"userId,omitempty"`
+	Email   string   `json:"email,omitempty"`
+	// Fingerprint identifies a stored secret without revealing it.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Diff        string `json:"diff,omitempty"`
+}
+
+// changeSet applies changes to the system and records them. In dry-run mode
+// the changes are only recorded and printed, nothing is modified.
+type changeSet struct {
+	p       *printer
+	dryRun  bool
+	changes []change
+}
+
+func (c *changeSet) record(ch change) {
+	c.changes = append(c.changes, ch)
+	if !c.dryRun {
+		return
+	}
+	switch ch.Action {
+	case "write":
+		c.p.Printf("Would write %s:\n%s", ch.Path, ch.Diff)
+	case "delete":
+		c.p.Printf("Would delete %s:\n%s", ch.Path, ch.Diff)
+	case "run":
+		c.p.Printf("Would run: %s\n", strings.Join(ch.Command, " "))
+	case "enroll":
+		c.p.Printf("Would log in as %s and store the key\n", firstNonEmpty(ch.Email, "the account"))
+	case "store-secret":
+		c.p.Printf("Would store the key for user %s (sha256 %s)\n", ch.UserID, ch.Fingerprint)
+	case "delete-secret":
+		c.p.Printf("Would delete the key for user %s\n", ch.UserID)
+	case "update-index":
+		c.p.Printf("Would update the account index: %s\n", ch.Diff)
+	case "update-pairings":
+		c.p.Printf("Would update the paired browsers: %s\n", ch.Diff)
+	case "update-limits":
+		c.p.Printf("Would update the unlock limits: %s\n", ch.Diff)
+	}
+}
+
+func (c *changeSet) writeFile(path string, content []byte, perm os.FileMode) error {
+	old, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	c.record(change{Action: "write", Path: path, Diff: lineDiff(string(old), string(content))})
+	if c.dryRun {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, perm)
+}
+
+func (c *changeSet) removeFile(path string) error {
+	old, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c.record(change{Action: "delete", Path: path, Diff: lineDiff(string(old), "")})
+	if c.dryRun {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// runPrivileged runs the command as root through pkexec.
+func (c *changeSet) runPrivileged(args ...string) error {
+	args = append([]string{"pkexec"}, args...)
+	c.record(change{Action: "run", Command: args})
+	if c.dryRun {
+		return nil
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = c.p.out
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// planEnroll records logging in as email and storing the key. Dry runs don't
+// log in, as that may ask for the password or a second factor.
+func (c *changeSet) planEnroll(email string) {
+	c.record(change{Action: "enroll", Email: email})
+}
+
+func (c *changeSet) setSecret(store secret.SecretStore, userID string, value string, meta secret.Metadata) error {
+	sum := sha256.Sum256([]byte(value))
+	c.record(change{Action: "store-secret", UserID: userID, Fingerprint: hex.EncodeToString(sum[:8])})
+	if c.dryRun {
+		return nil
+	}
+	return secret.SetSecretWithMetadata(store, userID, value, meta)
+}
+
+func (c *changeSet) deleteSecret(store secret.SecretStore, userID string) error {
+	c.record(change{Action: "delete-secret", UserID: userID})
+	if c.dryRun {
+		return nil
+	}
+	return store.DeleteSecret(userID)
+}
+
+// setIndex stores the account index. The summary describes the modification
+// for dry runs.
+func (c *changeSet) setIndex(store secret.SecretStore, value string, summary string) error {
+	c.record(change{Action: "update-index", Diff: summary})
+	if c.dryRun {
+		return nil
+	}
+	return secret.SetSecretWithMetadata(store, accountIndexID, value, secret.Metadata{
+		Label: "bw-bio-handler account index",
+	})
+}
+
+// saveLimits stores the unlock limits. The summary describes the
+// modification for dry runs.
+func (c *changeSet) saveLimits(l *unlockLimits, summary string) error {
+	c.record(change{Action: "update-limits", Diff: summary})
+	if c.dryRun {
+		return nil
+	}
+	return l.save()
+}
+
+// savePairings stores the paired browsers. The summary describes the
+// modification for dry runs.
+func (c *changeSet) savePairings(ps *pairings, summary string) error {
+	c.record(change{Action: "update-pairings", Diff: summary})
+	if c.dryRun {
+		return nil
+	}
+	return ps.save()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kenshaw/ini"
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+const (
+	defaultAPIURL      = "https://api.bitwarden.com"
+	defaultIdentityURL = "https://identity.bitwarden.com"
+)
+
+// config holds the settings read from the config file. Command line flags
+// and environment variables take precedence over these values.
+type config struct {
+	email        string
+	passwordFile string
+	apiURL       string
+	identityURL  string
+
+	clientID         string
+	clientSecretFile string
+
+	hostName          string
+	description       string
+	chromeExtensions  []string
+	mozillaExtensions []string
+
+	// secretBackend is a comma separated list of preferred secret backends.
+	secretBackend string
+	// secretCollection is the Secret Service collection of the keys.
+	secretCollection string
+	// fileKeyFile is the key file of the file secret backend.
+	fileKeyFile string
+	// filePath is the location of the secret file of the file backend.
+	filePath string
+	// maxAge is the period after which stored keys expire.
+	maxAge string
+	// authenticator is the authentication method of the handler.
+	authenticator string
+	// pamService is the PAM service of the pam authentication method.
+	pamService string
+	// gracePeriod is how long unlocks are allowed without authentication
+	// after a successful one.
+	gracePeriod string
+}
+
+// configPath returns the location of the config file, which can be
+// overridden with $BW_BIO_CONFIG.
+func configPath() (string, error) {
+	if path := os.Getenv("BW_BIO_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bw-bio-handler", "config"), nil
+}
+
+// dataDir returns the directory for state such as manifest backups, which
+// also holds the secret file of the file backend.
+func dataDir() (string, error) {
+	return secret.DataDir()
+}
+
+// writeFileAtomic replaces the file at path with data through a temporary
+// file, so that other handlers never read a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadConfig reads the config file. A missing file results in an empty
+// config.
+func loadConfig() (*config, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	file, err := ini.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config{}
+	for _, section := range file.AllSections() {
+		if section.Name() != "" {
+			return nil, fmt.Errorf("sections are not used in config files yet")
+		}
+		for _, key := range section.Keys() {
+			// note that these are lowercased
+			switch key {
+			case "email":
+				cfg.email = section.Get(key)
+			case "passwordfile":
+				cfg.passwordFile = section.Get(key)
+			case "apiurl":
+				cfg.apiURL = section.Get(key)
+			case "identityurl":
+				cfg.identityURL = section.Get(key)
+			case "clientid":
+				cfg.clientID = section.Get(key)
+			case "clientsecretfile":
+				cfg.clientSecretFile = section.Get(key)
+			case "hostname":
+				cfg.hostName = section.Get(key)
+			case "description":
+				cfg.description = section.Get(key)
+			case "chromeextensions":
+				cfg.chromeExtensions = splitList(section.Get(key))
+			case "mozillaextensions":
+				cfg.mozillaExtensions = splitList(section.Get(key))
+			case "secretbackend":
+				cfg.secretBackend = section.Get(key)
+			case "secretcollection":
+				cfg.secretCollection = section.Get(key)
+			case "filekeyfile":
+				cfg.fileKeyFile = section.Get(key)
+			case "filepath":
+				cfg.filePath = section.Get(key)
+			case "maxage":
+				cfg.maxAge = section.Get(key)
+			case "authenticator":
+				cfg.authenticator = section.Get(key)
+			case "pamservice":
+				cfg.pamService = section.Get(key)
+			case "graceperiod":
+				cfg.gracePeriod = section.Get(key)
+			default:
+				return nil, fmt.Errorf("unknown config key: %q", key)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// openSecretStore opens the secret backend preferred by $BW_BIO_SECRET_BACKEND
+// or the config file, falling back to the first available one. It returns the
+// name of the backend that was opened.
+func openSecretStore(cfg *config) (secret.SecretStore, string, error) {
+	store, name, err := secret.Open(firstNonEmpty(os.Getenv("BW_BIO_SECRET_BACKEND"), cfg.secretBackend), secretOptions(cfg))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get secret store: %v", err)
+	}
+	return store, name, nil
+}
+
+// secretOptions configures the secret backends from the environment and the
+// config file.
+func secretOptions(cfg *config) secret.Options {
+	return secret.Options{
+		Collection:     firstNonEmpty(os.Getenv("BW_BIO_SECRET_COLLECTION"), cfg.secretCollection),
+		FilePath:       firstNonEmpty(os.Getenv("BW_BIO_FILE_PATH"), cfg.filePath),
+		FileKeyFile:    firstNonEmpty(os.Getenv("BW_BIO_FILE_KEYFILE"), cfg.fileKeyFile),
+		FilePassphrase: os.Getenv("BW_BIO_FILE_PASSPHRASE"),
+	}
+}
+
+// firstNonEmpty returns the first of values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// splitList splits a comma separated list, dropping empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
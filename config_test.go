@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *config
+		err     bool
+	}{
+		{"empty", "", &config{}, false},
+		{"keys", "email = user@example.com\nApiURL = https://api.example.com\nchromeextensions = a, ,b\ngraceperiod = 5m\n", &config{
+			email:            "user@example.com",
+			apiURL:           "https://api.example.com",
+			chromeExtensions: []string{"a", "b"},
+			gracePeriod:      "5m",
+		}, false},
+		{"file backend", "filekeyfile = /keys/file.key\nfilepath = /data/secrets.json\n", &config{
+			fileKeyFile: "/keys/file.key",
+			filePath:    "/data/secrets.json",
+		}, false},
+		{"unknown key", "emial = user@example.com\n", nil, true},
+		{"section", "[server]\napiurl = https://api.example.com\n", nil, true},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "config")
+		if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("BW_BIO_CONFIG", path)
+		got, err := loadConfig()
+		if (err != nil) != test.err {
+			t.Errorf("%s: loadConfig() error = %v, want error: %v", test.name, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: loadConfig() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	t.Setenv("BW_BIO_CONFIG", filepath.Join(t.TempDir(), "missing"))
+	if cfg, err := loadConfig(); err != nil || !reflect.DeepEqual(cfg, &config{}) {
+		t.Errorf("loadConfig() = %+v, %v for a missing file, want an empty config", cfg, err)
+	}
+}
+
+func TestCredentialPrecedence(t *testing.T) {
+	cfg := &config{email: "config@example.com", apiURL: "https://config.example.com", identityURL: "https://identity.config.example.com"}
+	t.Setenv("BW_BIO_PASSWORD", "password")
+	t.Setenv("BW_BIO_API_URL", "https://env.example.com")
+	t.Setenv("BW_BIO_IDENTITY_URL", "")
+	t.Setenv("BW_BIO_EMAIL", "env@example.com")
+	f := &credentialFlags{passwordFD: -1, email: "flag@example.com"}
+
+	creds, err := f.resolve(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The flag beats the environment, which beats the config file.
+	if creds.email != "flag@example.com" || creds.apiURL != "https://env.example.com" || creds.identityURL != "https://identity.config.example.com" {
+		t.Errorf("resolve() = %+v", creds)
+	}
+	if creds.password != "password" {
+		t.Errorf("resolve() password = %q, want it from the environment", creds.password)
+	}
+}
+
+func TestFirstNonEmpty(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"", ""}, ""},
+		{[]string{"flag", "env", "config"}, "flag"},
+		{[]string{"", "env", "config"}, "env"},
+		{[]string{"", "", "config"}, "config"},
+	}
+	for _, test := range tests {
+		if got := firstNonEmpty(test.values...); got != test.want {
+			t.Errorf("firstNonEmpty(%q) = %q, want %q", test.values, got, test.want)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", nil},
+		{" , ,", nil},
+		{"firefox", []string{"firefox"}},
+		{"firefox, chrome,,brave ", []string{"firefox", "chrome", "brave"}},
+	}
+	for _, test := range tests {
+		if got := splitList(test.s); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitList(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// credentialFlags are the login inputs shared by every command that talks to
+// the Bitwarden server. Each value is taken from, in order: the command line,
+// the environment, the config file and finally an interactive prompt.
+type credentialFlags struct {
+	email        string
+	passwordFile string
+	passwordFD   int
+	apiURL       string
+	identityURL  string
+
+	clientID         string
+	clientSecretFile string
+}
+
+type credentials struct {
+	email       string
+	password    string
+	apiURL      string
+	identityURL string
+
+	// clientID and clientSecret are the optional personal API key.
+	clientID     string
+	clientSecret string
+}
+
+func (f *credentialFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "account email (env BW_BIO_EMAIL)")
+	fs.StringVar(&f.passwordFile, "password-file", "", "read the master password from the first line of this file (env BW_BIO_PASSWORD_FILE)")
+	fs.IntVar(&f.passwordFD, "password-fd", -1, "read the master password from this file descriptor")
+	fs.StringVar(&f.apiURL, "api-url", "", "API server URL (env BW_BIO_API_URL)")
+	fs.StringVar(&f.identityURL, "identity-url", "", "identity server URL (env BW_BIO_IDENTITY_URL)")
+	fs.StringVar(&f.clientID, "client-id", "", "personal API key client_id, logs in via API key instead of password (env BW_BIO_CLIENT_ID)")
+	fs.StringVar(&f.clientSecretFile, "client-secret-file", "", "read the personal API key client_secret from the first line of this file (env BW_BIO_CLIENT_SECRET_FILE)")
+}
+
+func (f *credentialFlags) resolve(cfg *config) (*credentials, error) {
+	var err error
+	creds := &credentials{}
+
+	creds.email = firstNonEmpty(f.email, os.Getenv("BW_BIO_EMAIL"), cfg.email)
+	if creds.email == "" {
+		if creds.email, err = promptLine("Email"); err != nil {
+			return nil, fmt.Errorf("no email given: %v", err)
+		}
+	}
+
+	creds.password, err = f.password(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	creds.clientID = firstNonEmpty(f.clientID, os.Getenv("BW_BIO_CLIENT_ID"), cfg.clientID)
+	if creds.clientID != "" {
+		if creds.clientSecret, err = f.clientSecret(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	creds.apiURL = firstNonEmpty(f.apiURL, os.Getenv("BW_BIO_API_URL"), cfg.apiURL)
+	creds.identityURL = firstNonEmpty(f.identityURL, os.Getenv("BW_BIO_IDENTITY_URL"), cfg.identityURL)
+	if creds.apiURL == "" && creds.identityURL == "" && stdinIsTerminal() {
+		creds.apiURL, _ = promptLine("API url (leave empty for default)")
+		creds.identityURL, _ = promptLine("Identity url (leave empty for default)")
+	}
+	creds.apiURL = firstNonEmpty(creds.apiURL, defaultAPIURL)
+	creds.identityURL = firstNonEmpty(creds.identityURL, defaultIdentityURL)
+
+	return creds, nil
+}
+
+func (f *credentialFlags) password(cfg *config) (string, error) {
+	if f.passwordFD >= 0 {
+		return readPasswordFD(f.passwordFD)
+	}
+	if path := firstNonEmpty(f.passwordFile, os.Getenv("BW_BIO_PASSWORD_FILE"), cfg.passwordFile); path != "" {
+		return readPasswordFile(path)
+	}
+	if password := os.Getenv("BW_BIO_PASSWORD"); password != "" {
+		return password, nil
+	}
+	password, err := promptPassword("Master password")
+	if err != nil {
+		return "", fmt.Errorf("no password given: %v", err)
+	}
+	return password, nil
+}
+
+func (f *credentialFlags) clientSecret(cfg *config) (string, error) {
+	if path := firstNonEmpty(f.clientSecretFile, os.Getenv("BW_BIO_CLIENT_SECRET_FILE"), cfg.clientSecretFile); path != "" {
+		return readPasswordFile(path)
+	}
+	if clientSecret := os.Getenv("BW_BIO_CLIENT_SECRET"); clientSecret != "" {
+		return clientSecret, nil
+	}
+	clientSecret, err := promptPassword("client_secret")
+	if err != nil {
+		return "", fmt.Errorf("no client_secret given: %v", err)
+	}
+	return clientSecret, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "a\nb\n", "+a\n+b\n"},
+		{"a\nb\n", "", "-a\n-b\n"},
+		{"a\nb\nc\n", "a\nb\nc\n", " a\n b\n c\n"},
+		{"a\nb\nc\n", "a\nx\nc\n", " a\n-b\n+x\n c\n"},
+		{"a\nc\n", "a\nb\nc\n", " a\n+b\n c\n"},
+	}
+	for _, test := range tests {
+		if got := lineDiff(test.a, test.b); got != test.want {
+			t.Errorf("lineDiff(%q, %q) = %q, want %q", test.a, test.b, got, test.want)
+		}
+	}
+}
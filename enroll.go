@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/pkg/bitw"
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+type enrollResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	DryRun bool   `json:"dryRun,omitempty"`
+	Email  string `json:"email,omitempty"`
+	UserID string `json:"userId,omitempty"`
+	// Changed is set when the newly derived key differs from the stored
+	// one, for example after a password change or KDF migration.
+	Changed            bool `json:"changed"`
+	PreviouslyEnrolled bool `json:"previouslyEnrolled"`
+	PINProtected       bool `json:"pinProtected,omitempty"`
+	// ExpiresAt is set when the key was stored with a maximum age.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Paired are the app ids of the browsers paired by this enrollment.
+	Paired        []string `json:"paired,omitempty"`
+	Browsers      []string `json:"browsers,omitempty"`
+	SecretBackend string   `json:"secretBackend,omitempty"`
+	Changes       []change `json:"changes,omitempty"`
+}
+
+// enrollment is the outcome of logging in and storing the key of an account.
+type enrollment struct {
+	userID             string
+	changed            bool
+	previouslyEnrolled bool
+	pinProtected       bool
+	// expiresAt is set when the key expires.
+	expiresAt *time.Time
+	// paired are the browsers that were paired.
+	paired []string
+	// browsers are the paired browsers that were given a copy of the key.
+	browsers []string
+	// backend is the name of the secret backend holding the key.
+	backend string
+}
+
+// runEnroll logs in again and replaces the stored key, which is needed after
+// a master password change or a KDF migration.
+func runEnroll(args []string) int {
+	var creds credentialFlags
+	var pin pinFlags
+	var maxAge maxAgeFlag
+	fs := flag.NewFlagSet("enroll", flag.ContinueOnError)
+	creds.register(fs)
+	pin.register(fs)
+	maxAge.register(fs)
+	pair := fs.String("pair", "", "comma separated app ids or browser names of pairing requests to pair, or \"all\" (see revoke --list)")
+	dryRun := fs.Bool("dry-run", false, "only print what would be changed")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	p := newPrinter(*jsonOutput)
+	changes := &changeSet{p: p, dryRun: *dryRun}
+	res := &enrollResult{Status: "ok", DryRun: *dryRun}
+	err := enroll(p, changes, &creds, &pin, &maxAge, *pair, res)
+	res.Changes = changes.changes
+	if err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+		p.result(res)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	p.result(res)
+	return 0
+}
+
+func enroll(p *printer, changes *changeSet, credFlags *credentialFlags, pinFlags *pinFlags, maxAgeFlag *maxAgeFlag, pair string, res *enrollResult) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("could not load config: %v", err)
+	}
+	creds, err := credFlags.resolve(cfg)
+	if err != nil {
+		return err
+	}
+	res.Email = creds.email
+	pin, err := pinFlags.resolve()
+	if err != nil {
+		return err
+	}
+	maxAge, err := maxAgeFlag.resolve(cfg)
+	if err != nil {
+		return err
+	}
+
+	e, err := enrollAccount(p, changes, cfg, creds, pin, pinFlags.disabled, maxAge, pair)
+	if e != nil {
+		res.UserID = e.userID
+		res.Changed = e.changed
+		res.PreviouslyEnrolled = e.previouslyEnrolled
+		res.PINProtected = e.pinProtected
+		res.ExpiresAt = e.expiresAt
+		res.Paired = e.paired
+		res.Browsers = e.browsers
+		res.SecretBackend = e.backend
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !e.previouslyEnrolled:
+		p.Printf("Enrolled user %s.\n", e.userID)
+	case e.changed:
+		p.Printf("The stored key of user %s was stale and has been replaced.\n", e.userID)
+	default:
+		p.Printf("The stored key of user %s is up to date.\n", e.userID)
+	}
+	if e.pinProtected {
+		p.Println("The key is protected by a PIN.")
+	}
+	if e.expiresAt != nil {
+		p.Printf("The key expires on %s, enroll again before then.\n", e.expiresAt.Local().Format(time.RFC1123))
+	}
+	return nil
+}
+
+// enrollAccount logs in, verifies the derived key against the account's
+// profile and stores it, unless the stored key is already up to date. With a
+// PIN, the key is stored wrapped with it; a key protected by a PIN is only
+// replaced by one without if noPIN is set. With a maximum age, the key is
+// stored with an expiry and always replaced, renewing it; keepMaxAge keeps
+// the maximum age of the stored key. The pairing requests matching pair are
+// paired, and every paired browser is given a copy of the key. The lockout
+// of the user is lifted.
+func enrollAccount(p *printer, changes *changeSet, cfg *config, creds *credentials, pin *pinOptions, noPIN bool, maxAge time.Duration, pair string) (*enrollment, error) {
+	p.Println("Getting secret...")
+	var err error
+	if creds.clientID != "" {
+		err = bitw.DoLoginWithAPIKey(creds.email, creds.password, creds.clientID, creds.clientSecret, creds.apiURL, creds.identityURL)
+	} else {
+		err = bitw.DoLogin(creds.email, creds.password, creds.apiURL, creds.identityURL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to login: %v", err)
+	}
+	encKey := bitw.GetEncKeyB64()
+	e := &enrollment{userID: bitw.GetUserID()}
+	if err := bitw.VerifyEncKeyB64(encKey); err != nil {
+		return e, fmt.Errorf("derived key does not decrypt the account key: %v", err)
+	}
+	p.Println("Got secret!")
+
+	store, backend, err := openSecretStore(cfg)
+	if err != nil {
+		return e, err
+	}
+	e.backend = backend
+	p.Printf("Using secret backend: %s\n", backend)
+	previous, err := store.GetSecret(e.userID)
+	if err != nil && !errors.Is(err, secret.ErrNotFound) {
+		return e, fmt.Errorf("failed to read stored secret: %v", err)
+	}
+	e.previouslyEnrolled = err == nil
+	e.pinProtected = pin != nil
+	if maxAge == keepMaxAge {
+		if maxAge, err = previousMaxAge(store, e.userID); err != nil {
+			return e, err
+		}
+		if maxAge > 0 {
+			p.Printf("Keeping the maximum age of %s, set --max-age 0 to remove it.\n", maxAge)
+		}
+	}
+	if value, _, err := secret.SplitExpiry(previous); err == nil && secret.IsPINWrapped(value) && pin == nil {
+		if !noPIN {
+			return e, errors.New("the stored key is protected by a PIN, pass --pin to keep a PIN or --no-pin to remove it")
+		}
+		p.Println("The stored key was protected by a PIN, it is replaced by one without.")
+	}
+	ps, newKeys, err := pairBrowsers(p, changes, store, pair)
+	if err != nil {
+		return e, err
+	}
+	for appID := range newKeys {
+		e.paired = append(e.paired, appID)
+	}
+	sort.Strings(e.paired)
+	changed, stored := replacesKey(e.userID, encKey, previous, pin, maxAge, len(newKeys) > 0)
+	e.changed = changed
+	enrolledAt := time.Now().UTC()
+	if stored {
+		value := encKey
+		if pin != nil {
+			if value, err = secret.WrapWithPIN(e.userID, encKey, pin.pin, pin.attempts); err != nil {
+				return e, err
+			}
+		}
+		meta := keyMetadata(creds, bitw.GetKDF(), enrolledAt)
+		if maxAge > 0 {
+			expiresAt := enrolledAt.Add(maxAge)
+			e.expiresAt = &expiresAt
+			value = secret.WithExpiry(value, expiresAt)
+			meta.Attributes["expires-at"] = expiresAt.Format(time.RFC3339)
+		}
+		if err := changes.setSecret(store, e.userID, value, meta); err != nil {
+			return e, fmt.Errorf("failed to store secret: %v", err)
+		}
+		if err := storeBrowserCopies(p, changes, store, ps, newKeys, e, value, meta); err != nil {
+			return e, err
+		}
+		if !changes.dryRun {
+			if err := setPINFailures(e.userID, 0); err != nil {
+				return e, fmt.Errorf("failed to reset the PIN failures: %v", err)
+			}
+		}
+	}
+
+	idx, err := loadAccountIndex(store)
+	if err != nil {
+		return e, err
+	}
+	if _, err := idx.find(e.userID); err != nil || stored {
+		idx.put(account{
+			UserID:      e.userID,
+			Email:       creds.email,
+			APIURL:      creds.apiURL,
+			IdentityURL: creds.identityURL,
+			EnrolledAt:  enrolledAt,
+			ExpiresAt:   e.expiresAt,
+		})
+		if err := idx.save(changes, store, "enroll "+e.userID+" ("+creds.email+")"); err != nil {
+			return e, fmt.Errorf("failed to update account index: %v", err)
+		}
+	}
+	// Enrolling proves the master password, so it lifts the lockout even if
+	// the stored key was up to date.
+	if !changes.dryRun {
+		if err := updateLimits(func(l *unlockLimits) { l.reset([]string{e.userID}) }); err != nil {
+			return e, fmt.Errorf("failed to reset the unlock limits: %v", err)
+		}
+	}
+	return e, nil
+}
+
+// replacesKey compares the key derived for the user with the previous value
+// as it is stored, and reports whether the key changed and whether it has to
+// be stored. A PIN wrapped or expiring key is always replaced, as the PIN or
+// the expiry may have changed, and so is the key when browsers were paired,
+// to give them their copies.
+func replacesKey(userID string, encKey string, previous string, pin *pinOptions, maxAge time.Duration, paired bool) (changed bool, store bool) {
+	previous, previousExpiry, err := secret.SplitExpiry(previous)
+	if err != nil {
+		// An unreadable key is replaced.
+		previous = ""
+	}
+	previousKey := previous
+	pinWrapped := secret.IsPINWrapped(previous)
+	if pinWrapped {
+		// The key can only be compared if the PIN is the same.
+		previousKey = ""
+		if w, err := secret.ParsePINWrapped(previous); err == nil && pin != nil {
+			previousKey, _ = w.Unwrap(userID, pin.pin)
+		}
+	}
+	changed = previousKey != encKey
+	return changed, changed || pin != nil || pinWrapped || maxAge > 0 || !previousExpiry.IsZero() || paired
+}
+
+// pairBrowsers pairs the browsers of the pairing requests matching query,
+// if any, and stores the keys wrapping their copies. It returns the
+// pairings and the new keys by app id, which aren't stored in dry runs.
+func pairBrowsers(p *printer, changes *changeSet, store secret.SecretStore, query string) (*pairings, map[string][]byte, error) {
+	unlock, err := lockPairings()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+	ps, err := loadPairings()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the paired browsers: %v", err)
+	}
+	if query == "" {
+		if len(ps.Requests) > 0 {
+			p.Printf("%d browsers asked to be paired, see revoke --list and pair them with --pair.\n", len(ps.Requests))
+		}
+		return ps, nil, nil
+	}
+	requests, err := ps.findRequests(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newKeys := make(map[string][]byte)
+	now := time.Now().UTC()
+	for _, r := range requests {
+		b, err := ps.pair(r, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := secret.NewWrappingKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := changes.setSecret(store, browserKeyID(b.AppID), base64.StdEncoding.EncodeToString(key), browserKeyMetadata(b)); err != nil {
+			return nil, nil, fmt.Errorf("failed to store the key of %s: %v", b.Browser, err)
+		}
+		newKeys[b.AppID] = key
+		p.Printf("Paired %s with app id %s started by %s.\n", b.Browser, b.AppID, b.Executable)
+	}
+	if err := changes.savePairings(ps, "pair "+query); err != nil {
+		return nil, nil, fmt.Errorf("failed to update the paired browsers: %v", err)
+	}
+	return ps, newKeys, nil
+}
+
+// storeBrowserCopies stores a copy of the key for every paired browser, with
+// the new keys of browsers paired just now. Browsers without a key have to be
+// paired again.
+func storeBrowserCopies(p *printer, changes *changeSet, store secret.SecretStore, ps *pairings, newKeys map[string][]byte, e *enrollment, value string, meta secret.Metadata) error {
+	for _, b := range ps.active() {
+		key, ok := newKeys[b.AppID]
+		if !ok {
+			var err error
+			key, err = browserKey(store, b)
+			if errors.Is(err, secret.ErrNotFound) {
+				p.Printf("%s with app id %s has no key, pair it again.\n", b.Browser, b.AppID)
+				continue
+			} else if err != nil {
+				return fmt.Errorf("failed to read the key of %s: %v", b.Browser, err)
+			}
+		}
+		wrapped, err := wrapCopy(key, b, e.userID, value)
+		if err != nil {
+			return err
+		}
+		if err := changes.setSecret(store, copyID(e.userID, b.AppID), wrapped, copyMetadata(meta, b)); err != nil {
+			return fmt.Errorf("failed to store the copy for %s: %v", b.Browser, err)
+		}
+		e.browsers = append(e.browsers, b.AppID)
+	}
+	if len(e.browsers) > 0 {
+		p.Printf("Stored copies of the key for %d paired browsers.\n", len(e.browsers))
+	}
+	return nil
+}
+
+// accountMetadata describes the key of an enrolled account, for when the
+// metadata it was stored with isn't available.
+func accountMetadata(a account) secret.Metadata {
+	meta := keyMetadata(&credentials{email: a.Email, apiURL: a.APIURL, identityURL: a.IdentityURL}, "", a.EnrolledAt)
+	delete(meta.Attributes, "kdf")
+	if a.ExpiresAt != nil {
+		meta.Attributes["expires-at"] = a.ExpiresAt.Format(time.RFC3339)
+	}
+	return meta
+}
+
+// keyMetadata describes the key of an account, so that it can be recognized
+// in keyring managers such as Seahorse.
+func keyMetadata(creds *credentials, kdf string, enrolledAt time.Time) secret.Metadata {
+	server := creds.apiURL
+	if u, err := url.Parse(creds.apiURL); err == nil && u.Host != "" {
+		server = u.Host
+	}
+	return secret.Metadata{
+		Label: fmt.Sprintf("Bitwarden key of %s (%s)", creds.email, server),
+		Attributes: map[string]string{
+			"email":        creds.email,
+			"server":       creds.apiURL,
+			"identity-url": creds.identityURL,
+			"kdf":          kdf,
+			"enrolled-at":  enrolledAt.Format(time.RFC3339),
+		},
+	}
+}
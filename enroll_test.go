@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+func TestReplacesKey(t *testing.T) {
+	wrapped, err := secret.WrapWithPIN("user", "key", "1234", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiring := secret.WithExpiry("key", time.Now().Add(time.Hour))
+	pin := &pinOptions{pin: "1234", attempts: 3}
+	otherPIN := &pinOptions{pin: "0000", attempts: 3}
+
+	tests := []struct {
+		name     string
+		previous string
+		pin      *pinOptions
+		maxAge   time.Duration
+		paired   bool
+		changed  bool
+		store    bool
+	}{
+		{"not enrolled", "", nil, 0, false, true, true},
+		{"up to date", "key", nil, 0, false, false, false},
+		{"stale", "old key", nil, 0, false, true, true},
+		{"paired", "key", nil, 0, true, false, true},
+		{"max age", "key", nil, time.Hour, false, false, true},
+		{"expiring", expiring, nil, 0, false, false, true},
+		{"new PIN", "key", pin, 0, false, false, true},
+		{"same PIN", wrapped, pin, 0, false, false, true},
+		{"other PIN", wrapped, otherPIN, 0, false, true, true},
+		{"PIN removed", wrapped, nil, 0, false, true, true},
+	}
+	for _, test := range tests {
+		changed, store := replacesKey("user", "key", test.previous, test.pin, test.maxAge, test.paired)
+		if changed != test.changed || store != test.store {
+			t.Errorf("%s: replacesKey() = %v, %v, want %v, %v", test.name, changed, store, test.changed, test.store)
+		}
+	}
+}
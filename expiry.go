@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/logging"
+	"github.com/quexten/bw-bio-handler/notify"
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+// maxAgeFlag sets after how long the stored key expires.
+type maxAgeFlag struct {
+	value string
+}
+
+func (f *maxAgeFlag) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.value, "max-age", "", "expire the stored key after this long, f.e. 720h or 90d, after which it has to be enrolled again; 0 for never, the default keeps the previous one (env BW_BIO_MAX_AGE)")
+}
+
+// keepMaxAge is resolved when no maximum age is set, to keep the one the
+// stored key was enrolled with.
+const keepMaxAge time.Duration = -1
+
+// resolve returns the maximum age of the key, 0 if it doesn't expire, or
+// keepMaxAge.
+func (f *maxAgeFlag) resolve(cfg *config) (time.Duration, error) {
+	value := firstNonEmpty(f.value, os.Getenv("BW_BIO_MAX_AGE"), cfg.maxAge)
+	if value == "" {
+		return keepMaxAge, nil
+	}
+	maxAge, err := parseMaxAge(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max age %q: %v", value, err)
+	}
+	return maxAge, nil
+}
+
+// parseMaxAge parses a duration, which may also be given in days, f.e. 90d.
+func parseMaxAge(value string) (time.Duration, error) {
+	var maxAge time.Duration
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		maxAge = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if maxAge, err = time.ParseDuration(value); err != nil {
+			return 0, err
+		}
+	}
+	if maxAge < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return maxAge, nil
+}
+
+// previousMaxAge returns the maximum age the key of the user was enrolled
+// with, 0 if it doesn't expire or the user isn't enrolled.
+func previousMaxAge(store secret.SecretStore, userID string) (time.Duration, error) {
+	idx, err := loadAccountIndex(store)
+	if err != nil {
+		return 0, err
+	}
+	a, err := idx.find(userID)
+	if err != nil || a.ExpiresAt == nil {
+		return 0, nil
+	}
+	return a.ExpiresAt.Sub(a.EnrolledAt), nil
+}
+
+// sendNotification shows a desktop notification. On Windows, where
+// notifications aren't implemented, it fails and nothing is shown. It is
+// replaced in tests.
+var sendNotification = notify.Send
+
+// notifyExpired tells the user that the key of the account expired and has
+// to be enrolled again.
+func notifyExpired(store secret.SecretStore, userID string) {
+	name := firstNonEmpty(accountEmail(store, userID), userID)
+	body := fmt.Sprintf("The stored key of %s has expired. Run \"bw-bio-handler enroll\" to use biometric unlock again.", name)
+	if err := sendNotification("Biometric unlock expired", body); err != nil {
+		logging.Errorf("Could not send notification: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/biometrics"
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+func TestParseMaxAge(t *testing.T) {
+	tests := map[string]time.Duration{
+		"90d":  90 * 24 * time.Hour,
+		"720h": 720 * time.Hour,
+		"0":    0,
+	}
+	for value, want := range tests {
+		got, err := parseMaxAge(value)
+		if err != nil {
+			t.Errorf("parseMaxAge(%q): %v", value, err)
+		} else if got != want {
+			t.Errorf("parseMaxAge(%q) = %v, want %v", value, got, want)
+		}
+	}
+	for _, value := range []string{"", "d", "1.5d", "-1h", "soon"} {
+		if _, err := parseMaxAge(value); err == nil {
+			t.Errorf("parseMaxAge(%q) succeeded", value)
+		}
+	}
+}
+
+func TestMaxAgeFlag(t *testing.T) {
+	t.Setenv("BW_BIO_MAX_AGE", "")
+	tests := []struct {
+		value string
+		cfg   string
+		want  time.Duration
+	}{
+		{"", "", keepMaxAge},
+		{"0", "90d", 0},
+		{"", "90d", 90 * 24 * time.Hour},
+		{"720h", "90d", 720 * time.Hour},
+	}
+	for _, test := range tests {
+		f := &maxAgeFlag{value: test.value}
+		got, err := f.resolve(&config{maxAge: test.cfg})
+		if err != nil || got != test.want {
+			t.Errorf("resolve(%q, config %q) = %v, %v, want %v", test.value, test.cfg, got, err, test.want)
+		}
+	}
+}
+
+func TestPreviousMaxAge(t *testing.T) {
+	store := secret.NewMemorySecretStore()
+	enrolledAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiresAt := enrolledAt.Add(90 * 24 * time.Hour)
+	idx, err := loadAccountIndex(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.put(account{UserID: "expiring", EnrolledAt: enrolledAt, ExpiresAt: &expiresAt})
+	idx.put(account{UserID: "forever", EnrolledAt: enrolledAt})
+	if err := idx.save(&changeSet{p: newPrinter(true)}, store, ""); err != nil {
+		t.Fatal(err)
+	}
+	for userID, want := range map[string]time.Duration{"expiring": 90 * 24 * time.Hour, "forever": 0, "unknown": 0} {
+		if got, err := previousMaxAge(store, userID); err != nil || got != want {
+			t.Errorf("previousMaxAge(%s) = %v, %v, want %v", userID, got, err, want)
+		}
+	}
+}
+
+func TestUnlockKeyExpired(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	var notified []string
+	send := sendNotification
+	defer func() { sendNotification = send }()
+	sendNotification = func(summary string, body string) error {
+		notified = append(notified, summary)
+		return nil
+	}
+
+	store := secret.NewMemorySecretStore()
+	if err := store.SetSecret("valid", secret.WithExpiry("key", time.Now().Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetSecret("expired", secret.WithExpiry("key", time.Now().Add(-time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	auth := authResult(biometrics.Granted)
+
+	if response, key := unlockKey(store, "valid", "app", auth, answers()); response != responseUnlocked || key != "key" {
+		t.Fatalf("unlockKey() = %q, %q for a valid key, want unlocked", response, key)
+	}
+	// The browser copies carry the expiry as well.
+	pairTestBrowser(t, store, "app", "valid", "expired")
+	if response, key := unlockKey(store, "valid", "app", auth, answers()); response != responseUnlocked || key != "key" {
+		t.Fatalf("unlockKey() = %q, %q for the browser copy, want unlocked", response, key)
+	}
+	if len(notified) != 0 {
+		t.Fatalf("Notified %v for a valid key", notified)
+	}
+
+	if response, key := unlockKey(store, "expired", "app", auth, answers()); response != responseExpired || key != "" {
+		t.Fatalf("unlockKey() = %q, %q for an expired key, want expired", response, key)
+	}
+	if len(notified) != 1 {
+		t.Fatalf("Sent %d notifications for an expired key, want 1", len(notified))
+	}
+}
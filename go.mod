@@ -5,6 +5,7 @@ go 1.19
 require (
 	github.com/amenzhinsky/go-polkit v0.0.0-20210519083301-ee6a51849123
 	github.com/danieljoos/wincred v1.1.2
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/google/uuid v1.3.0
 	github.com/kenshaw/ini v0.5.1
 	github.com/keybase/dbus v0.0.0-20220506165403-5aa21ea2c23a
@@ -12,13 +13,12 @@ require (
 	github.com/lox/go-touchid v0.0.0-20170712105233-619cc8e578d0
 	golang.org/x/crypto v0.7.0
 	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.6.0
 	golang.org/x/term v0.6.0
 	rsc.io/2fa v1.2.0
 )
 
 require (
 	github.com/atotto/clipboard v0.1.4 // indirect
-	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	golang.org/x/sys v0.6.0 // indirect
 )
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/internal/lockedfile"
+	"github.com/quexten/bw-bio-handler/logging"
+	"github.com/quexten/bw-bio-handler/session"
+)
+
+// sleepTolerance is how much the sleep time may change between reading it
+// at the start and at the use of a grace period without counting as sleep,
+// as it is computed from two clocks.
+const sleepTolerance = time.Second
+
+// gracePeriod is how long unlocks of a user are allowed without
+// authentication after the user authenticated, 0 if they aren't. It is set
+// by the handler from $BW_BIO_GRACE_PERIOD or the config file.
+var gracePeriod time.Duration
+
+// parseGracePeriod parses a number of seconds or a duration, f.e. 90 or 5m.
+func parseGracePeriod(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	period, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, serr := strconv.Atoi(value)
+		if serr != nil {
+			return 0, fmt.Errorf("invalid grace period %q: %v", value, err)
+		}
+		period = time.Duration(seconds) * time.Second
+	}
+	if period < 0 {
+		return 0, fmt.Errorf("invalid grace period %q: must not be negative", value)
+	}
+	return period, nil
+}
+
+// graceGrant is the successful authentication a grace period starts at.
+type graceGrant struct {
+	GrantedAt time.Time `json:"grantedAt"`
+	// SleepTime is the time the system had been asleep at GrantedAt, which
+	// changes when it sleeps.
+	SleepTime time.Duration `json:"sleepTime"`
+}
+
+// gracePath is the file of the grace periods, which every handler shares.
+// It is kept in the runtime directory, so that it goes away on logout.
+func gracePath() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "bw-bio-handler", "grace.json"), nil
+	}
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "grace.json"), nil
+}
+
+func loadGrants() (map[string]graceGrant, error) {
+	path, err := gracePath()
+	if err != nil {
+		return nil, err
+	}
+	grants := make(map[string]graceGrant)
+	bs, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return grants, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &grants); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	return grants, nil
+}
+
+func saveGrants(grants map[string]graceGrant) error {
+	path, err := gracePath()
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(grants)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bs)
+}
+
+// lockGrants serializes changes of the grace periods with other handlers.
+// The returned function releases the lock.
+func lockGrants() (func(), error) {
+	path, err := gracePath()
+	if err != nil {
+		return nil, err
+	}
+	return lockedfile.Lock(path)
+}
+
+// inGracePeriod reports whether the user authenticated less than the grace
+// period ago, and the system hasn't slept since.
+func inGracePeriod(userID string) bool {
+	if gracePeriod <= 0 {
+		return false
+	}
+	grants, err := loadGrants()
+	if err != nil {
+		logging.Errorf("Could not read the grace periods: %v", err)
+		return false
+	}
+	g, ok := grants[userID]
+	if !ok {
+		return false
+	}
+	elapsed := time.Since(g.GrantedAt)
+	slept := session.SleepTime() - g.SleepTime
+	return elapsed >= 0 && elapsed < gracePeriod && slept < sleepTolerance && slept > -sleepTolerance
+}
+
+// startGracePeriod starts the grace period of the user after a successful
+// authentication.
+func startGracePeriod(userID string) {
+	if gracePeriod <= 0 {
+		return
+	}
+	unlock, err := lockGrants()
+	if err != nil {
+		logging.Errorf("Could not save the grace period: %v", err)
+		return
+	}
+	defer unlock()
+	grants, err := loadGrants()
+	if err != nil {
+		logging.Errorf("Could not read the grace periods: %v", err)
+		return
+	}
+	now := time.Now()
+	for id, g := range grants {
+		if now.Sub(g.GrantedAt) >= gracePeriod {
+			delete(grants, id)
+		}
+	}
+	grants[userID] = graceGrant{GrantedAt: now, SleepTime: session.SleepTime()}
+	if err := saveGrants(grants); err != nil {
+		logging.Errorf("Could not save the grace period: %v", err)
+	}
+}
+
+// endGracePeriods ends the grace periods of all users.
+func endGracePeriods() {
+	path, err := gracePath()
+	if err != nil {
+		logging.Errorf("Could not end the grace periods: %v", err)
+		return
+	}
+	unlock, err := lockGrants()
+	if err != nil {
+		logging.Errorf("Could not end the grace periods: %v", err)
+		return
+	}
+	defer unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logging.Errorf("Could not end the grace periods: %v", err)
+	}
+}
+
+// watchGracePeriods ends the grace periods when the screen is locked or the
+// system goes to sleep, for as long as the handler runs.
+func watchGracePeriods() {
+	if gracePeriod <= 0 {
+		return
+	}
+	go func() {
+		err := session.WatchLock(context.Background(), func() {
+			logging.Debugf("Screen locked or going to sleep, ending the grace periods")
+			endGracePeriods()
+		})
+		if err != nil {
+			logging.Errorf("Could not watch the screen lock: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/biometrics"
+	"github.com/quexten/bw-bio-handler/secret"
+	"github.com/quexten/bw-bio-handler/session"
+)
+
+func TestParseGracePeriod(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{"", 0, false},
+		{"90", 90 * time.Second, false},
+		{"5m", 5 * time.Minute, false},
+		{"-1", 0, true},
+		{"soon", 0, true},
+	}
+	for _, test := range tests {
+		got, err := parseGracePeriod(test.value)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("parseGracePeriod(%q) = %v, %v", test.value, got, err)
+		}
+	}
+}
+
+func TestUnlockKeyGracePeriod(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	defer func(period time.Duration) { gracePeriod = period }(gracePeriod)
+	gracePeriod = time.Minute
+	defer func(enabled bool) { unlockLimitsEnabled = enabled }(unlockLimitsEnabled)
+	unlockLimitsEnabled = false
+
+	store := secret.NewMemorySecretStore()
+	if err := store.SetSecret("user", "key"); err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	result := biometrics.Denied
+	auth := biometrics.AuthenticatorFunc(func(ctx context.Context, req biometrics.Request) (biometrics.Result, error) {
+		calls++
+		return result, nil
+	})
+	unlock := func(want string) {
+		t.Helper()
+		if response, _ := unlockKey(store, "user", "app", auth, answers()); response != want {
+			t.Errorf("unlockKey() = %q, want %q", response, want)
+		}
+	}
+
+	unlock(responseDenied)
+	unlock(responseDenied)
+	result = biometrics.Granted
+	unlock(responseUnlocked)
+	result = biometrics.Denied
+	unlock(responseUnlocked)
+	if calls != 3 {
+		t.Errorf("authenticated %d times, want 3", calls)
+	}
+	if inGracePeriod("other") {
+		t.Error("grace period applies to another user")
+	}
+
+	endGracePeriods()
+	unlock(responseDenied)
+	if calls != 4 {
+		t.Errorf("authenticated %d times after the grace period ended, want 4", calls)
+	}
+
+	grants := map[string]graceGrant{
+		"expired": {GrantedAt: time.Now().Add(-2 * time.Minute), SleepTime: session.SleepTime()},
+		"slept":   {GrantedAt: time.Now(), SleepTime: session.SleepTime() - time.Hour},
+	}
+	if err := saveGrants(grants); err != nil {
+		t.Fatal(err)
+	}
+	for userID := range grants {
+		if inGracePeriod(userID) {
+			t.Errorf("%s: grace period didn't end", userID)
+		}
+	}
+}
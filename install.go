@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	policyName    = "com.quexten.bw-bio-handler.policy"
+	policyDir     = "/usr/share/polkit-1/actions/"
+	pamServiceDir = "/etc/pam.d/"
+)
+
+type installResult struct {
+	Status    string   `json:"status"`
+	Error     string   `json:"error,omitempty"`
+	DryRun    bool     `json:"dryRun,omitempty"`
+	Email     string   `json:"email,omitempty"`
+	UserID    string   `json:"userId,omitempty"`
+	Manifests []string `json:"manifests,omitempty"`
+	// SecretBackend is the name of the secret backend the key is stored in.
+	SecretBackend string `json:"secretBackend,omitempty"`
+	// ExpiresAt is set when the key was stored with a maximum age.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Changes   []change   `json:"changes,omitempty"`
+}
+
+// handlerPath is the path of the binary the manifests point to.
+func handlerPath() string {
+	return os.Getenv("PWD") + "/bw-bio-handler"
+}
+
+func runInstall(args []string) int {
+	var creds credentialFlags
+	var manifests manifestFlags
+	var pin pinFlags
+	var maxAge maxAgeFlag
+	fs := flag.NewFlagSet("install", flag.ContinueOnError)
+	creds.register(fs)
+	manifests.register(fs)
+	pin.register(fs)
+	maxAge.register(fs)
+	browserSelection := fs.String("browser", "", "comma separated browsers to install the manifest for, or \"all\" ("+strings.Join(browserNames(), ", ")+"); defaults to the installed ones")
+	dryRun := fs.Bool("dry-run", false, "only print what would be changed")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	p := newPrinter(*jsonOutput)
+	changes := &changeSet{p: p, dryRun: *dryRun}
+	res := &installResult{Status: "ok", DryRun: *dryRun}
+	err := install(p, changes, &creds, &manifests, &pin, &maxAge, *browserSelection, res)
+	res.Changes = changes.changes
+	if err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+		p.result(res)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	p.result(res)
+	return 0
+}
+
+func install(p *printer, changes *changeSet, credFlags *credentialFlags, manifestFlags *manifestFlags, pinFlags *pinFlags, maxAgeFlag *maxAgeFlag, browserSelection string, res *installResult) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("could not load config: %v", err)
+	}
+	// Resolve the credentials first, so that scripts fail before anything is
+	// changed on the system. Dry runs don't log in, so they only need the
+	// email, if it is known.
+	var creds *credentials
+	if changes.dryRun {
+		res.Email = firstNonEmpty(credFlags.email, os.Getenv("BW_BIO_EMAIL"), cfg.email)
+	} else {
+		if creds, err = credFlags.resolve(cfg); err != nil {
+			return err
+		}
+		res.Email = creds.email
+	}
+	pin, err := pinFlags.resolve()
+	if err != nil {
+		return err
+	}
+	maxAge, err := maxAgeFlag.resolve(cfg)
+	if err != nil {
+		return err
+	}
+
+	home := os.Getenv("HOME")
+	selected, err := selectBrowsers(browserSelection, home)
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("no supported browser found, select one with --browser")
+	}
+	manifestOpts := manifestFlags.resolve(cfg, handlerPath())
+	planned, err := planManifests(selected, home, manifestOpts)
+	if err != nil {
+		return err
+	}
+
+	p.Println("Installing...")
+	p.Println("Copying polkit policy...")
+	workdir := os.Getenv("PWD")
+	_ = changes.runPrivileged("cp", workdir+"/biometrics/policies/"+policyName, policyDir)
+
+	// check file exists
+	if !changes.dryRun {
+		_, err = os.Stat(policyDir + policyName)
+		if err != nil {
+			return fmt.Errorf("failed to copy polkit policy: %v", err)
+		}
+	}
+
+	if err := installPAMService(p, changes, cfg, workdir); err != nil {
+		return err
+	}
+
+	p.Println("Installing browser manifests...")
+	manifests, err := installManifests(p, changes, planned, manifestOpts.hostName)
+	res.Manifests = manifests
+	if err != nil {
+		return fmt.Errorf("failed to install browser manifests: %v", err)
+	}
+
+	if changes.dryRun {
+		changes.planEnroll(res.Email)
+		p.Println("Dry run, nothing was changed.")
+		return nil
+	}
+	e, err := enrollAccount(p, changes, cfg, creds, pin, pinFlags.disabled, maxAge, "")
+	if e != nil {
+		res.UserID = e.userID
+		res.SecretBackend = e.backend
+		res.ExpiresAt = e.expiresAt
+	}
+	if err != nil {
+		return err
+	}
+
+	p.Println("Done!")
+	p.Println("You can now activate the biometrics support in your browser. Enjoy!")
+	return nil
+}
+
+// hasPAMService reports whether the system uses PAM services stacking
+// pam_fprintd and pam_unix, which macOS doesn't.
+func hasPAMService() bool {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		return false
+	}
+	_, err := os.Stat(pamServiceDir)
+	return err == nil
+}
+
+// installPAMService copies the PAM service of the pam authentication method,
+// if it is selected. An existing service is kept, as the administrator may
+// have adapted it.
+func installPAMService(p *printer, changes *changeSet, cfg *config, workdir string) error {
+	if authMethod(cfg) != "pam" || !hasPAMService() {
+		return nil
+	}
+	if _, err := os.Stat(pamServiceDir + pamService); err == nil {
+		p.Printf("Keeping existing PAM service %s\n", pamServiceDir+pamService)
+		return nil
+	}
+	p.Println("Copying PAM service...")
+	if err := changes.runPrivileged("cp", workdir+"/biometrics/pam.d/"+pamService, pamServiceDir); err != nil {
+		return fmt.Errorf("failed to copy PAM service: %v", err)
+	}
+	return nil
+}
+
+// isInstalledPAMService reports whether the installed PAM service is the one
+// shipped with bw-bio-handler, and not adapted.
+func isInstalledPAMService(workdir string) bool {
+	installed, err := os.ReadFile(pamServiceDir + pamService)
+	if err != nil {
+		return false
+	}
+	shipped, err := os.ReadFile(workdir + "/biometrics/pam.d/" + pamService)
+	return err == nil && bytes.Equal(installed, shipped)
+}
+
+// plannedManifest is a manifest that has been generated, but not written yet.
+type plannedManifest struct {
+	browser browser
+	path    string
+	content []byte
+}
+
+// planManifests generates the manifests for the selected browsers, so that
+// invalid options are reported before anything is written.
+func planManifests(selected []browser, home string, opts *manifestOptions) ([]plannedManifest, error) {
+	var planned []plannedManifest
+	seen := make(map[string]bool)
+	for _, b := range selected {
+		path := b.manifestPath(home, opts.fileName())
+		// Some browsers share a manifest directory.
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		content, err := opts.manifest(b.flavour)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.displayName, err)
+		}
+		planned = append(planned, plannedManifest{browser: b, path: path, content: content})
+	}
+	return planned, nil
+}
+
+// installManifests writes the planned manifests, creating the manifest
+// directories where they are missing. Manifests of other applications
+// registered under the same name are backed up first.
+func installManifests(p *printer, changes *changeSet, planned []plannedManifest, hostName string) ([]string, error) {
+	var manifests []string
+	for _, m := range planned {
+		if _, err := backupManifest(p, changes, m.browser, m.path, hostName); err != nil {
+			return manifests, fmt.Errorf("failed to back up %s: %v", m.path, err)
+		}
+		p.Printf("Installing manifest for %s: %s\n", m.browser.displayName, m.path)
+		if err := changes.writeFile(m.path, m.content, 0644); err != nil {
+			return manifests, err
+		}
+		manifests = append(manifests, m.path)
+	}
+	return manifests, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstallDryRun(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+	t.Setenv("BW_BIO_CONFIG", filepath.Join(home, "config"))
+	t.Setenv("BW_BIO_EMAIL", "user@example.com")
+
+	// Without a password, a login would have to prompt for it.
+	p := newPrinter(true)
+	changes := &changeSet{p: p, dryRun: true}
+	res := &installResult{}
+	creds := &credentialFlags{passwordFD: -1}
+	if err := install(p, changes, creds, &manifestFlags{}, &pinFlags{}, &maxAgeFlag{}, "firefox", res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Email != "user@example.com" || res.UserID != "" {
+		t.Errorf("install() = %+v, want the email and no user id", res)
+	}
+	last := changes.changes[len(changes.changes)-1]
+	if last.Action != "enroll" || last.Email != "user@example.com" {
+		t.Errorf("last change %+v, want enrolling user@example.com", last)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".mozilla")); err == nil {
+		t.Error("dry run wrote the manifest")
+	}
+}
@@ -0,0 +1,30 @@
+// Package lockedfile serializes the read-modify-write cycles of files that
+// several handler processes share, as every browser starts its own handler.
+package lockedfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Lock takes an exclusive lock for the file at path, waiting for other
+// processes holding it. The lock is taken on a separate path+".lock" file,
+// so that the file itself can be replaced by renaming. The returned function
+// releases the lock.
+func Lock(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlock(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,43 @@
+package lockedfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/quexten/bw-bio-handler/internal/lockedfile"
+)
+
+func TestLockSerializesUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter")
+	const updates = 50
+	var wg sync.WaitGroup
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockedfile.Lock(path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer unlock()
+			bs, _ := os.ReadFile(path)
+			n, _ := strconv.Atoi(string(bs))
+			if err := os.WriteFile(path, []byte(strconv.Itoa(n+1)), 0600); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != strconv.Itoa(updates) {
+		t.Fatalf("Counter is %s after %d locked updates", bs, updates)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/internal/lockedfile"
+	"github.com/quexten/bw-bio-handler/logging"
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+const (
+	// rateLimitPrompts is the number of prompts a caller may cause per
+	// rateLimitWindow.
+	rateLimitPrompts = 5
+	rateLimitWindow  = time.Minute
+	// backoffBase is how long a caller has to wait for the next prompt after
+	// a failed authentication. It doubles with every further failure in a
+	// row, up to backoffMax.
+	backoffBase = 2 * time.Second
+	backoffMax  = 5 * time.Minute
+	// lockoutFailures is the number of denied or timed out authentications of
+	// a user in a row after which unlocks are refused until the account is
+	// enrolled again or unlock-reset is run. Dismissed prompts only add to the
+	// backoff, as anyone can cause them and the lockout doesn't expire.
+	lockoutFailures = 10
+)
+
+// unlockLimitsEnabled can be cleared by tests unlocking over and over.
+var unlockLimitsEnabled = true
+
+var (
+	errLockedOut   = errors.New("locked out after too many failed authentications")
+	errRateLimited = errors.New("too many unlock requests")
+)
+
+// callerLimit tracks the prompts caused by a caller, which is told apart by
+// its executable, see parentExecutable. Unlike the app id of the messages,
+// it can't be picked freely by the caller.
+type callerLimit struct {
+	// Prompts are the times of the prompts within the rate limit window.
+	Prompts     []time.Time `json:"prompts,omitempty"`
+	Failures    int         `json:"failures,omitempty"`
+	LastFailure time.Time   `json:"lastFailure,omitempty"`
+}
+
+// userLimit tracks the failed authentications of a user.
+type userLimit struct {
+	Failures    int        `json:"failures,omitempty"`
+	LockedOutAt *time.Time `json:"lockedOutAt,omitempty"`
+}
+
+// unlockLimits keeps unlock requests from prompting the user over and over,
+// so that they don't authenticate out of fatigue. They are kept on disk, so
+// that restarting the handler doesn't reset them.
+type unlockLimits struct {
+	Callers map[string]*callerLimit `json:"callers,omitempty"`
+	Users   map[string]*userLimit   `json:"users,omitempty"`
+}
+
+func limitsPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "unlock-limits.json"), nil
+}
+
+func loadLimits() (*unlockLimits, error) {
+	path, err := limitsPath()
+	if err != nil {
+		return nil, err
+	}
+	l := &unlockLimits{}
+	bs, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	} else if err == nil {
+		if err := json.Unmarshal(bs, l); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", path, err)
+		}
+	}
+	if l.Callers == nil {
+		l.Callers = make(map[string]*callerLimit)
+	}
+	if l.Users == nil {
+		l.Users = make(map[string]*userLimit)
+	}
+	return l, nil
+}
+
+func (l *unlockLimits) save() error {
+	path, err := limitsPath()
+	if err != nil {
+		return err
+	}
+	bs, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bs)
+}
+
+// lockLimits serializes changes of the limits with other handlers. The
+// returned function releases the lock.
+func lockLimits() (func(), error) {
+	path, err := limitsPath()
+	if err != nil {
+		return nil, err
+	}
+	return lockedfile.Lock(path)
+}
+
+// updateLimits applies update to the limits on disk, which are reloaded
+// under the lock as other handlers may have changed them.
+func updateLimits(update func(l *unlockLimits)) error {
+	unlock, err := lockLimits()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	l, err := loadLimits()
+	if err != nil {
+		return err
+	}
+	update(l)
+	l.prune(time.Now())
+	return l.save()
+}
+
+func (l *unlockLimits) caller(name string) *callerLimit {
+	c, ok := l.Callers[name]
+	if !ok {
+		c = &callerLimit{}
+		l.Callers[name] = c
+	}
+	return c
+}
+
+func (l *unlockLimits) user(userID string) *userLimit {
+	u, ok := l.Users[userID]
+	if !ok {
+		u = &userLimit{}
+		l.Users[userID] = u
+	}
+	return u
+}
+
+// backoff returns how long the caller has to wait after its last failure.
+func (c *callerLimit) backoff() time.Duration {
+	if c.Failures == 0 {
+		return 0
+	}
+	backoff := backoffBase
+	for i := 1; i < c.Failures && backoff < backoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > backoffMax {
+		backoff = backoffMax
+	}
+	return backoff
+}
+
+// allow checks whether the caller may prompt the user now, and records the
+// prompt if it may.
+func (l *unlockLimits) allow(userID string, caller string, now time.Time) error {
+	if u, ok := l.Users[userID]; ok && u.LockedOutAt != nil {
+		return errLockedOut
+	}
+	c := l.caller(caller)
+	if wait := c.LastFailure.Add(c.backoff()).Sub(now); wait > 0 {
+		return fmt.Errorf("%w, retry in %s", errRateLimited, wait.Round(time.Second))
+	}
+	var recent []time.Time
+	for _, t := range c.Prompts {
+		if now.Sub(t) < rateLimitWindow {
+			recent = append(recent, t)
+		}
+	}
+	c.Prompts = recent
+	if len(c.Prompts) >= rateLimitPrompts {
+		return fmt.Errorf("%w, %d in the last %s", errRateLimited, len(c.Prompts), rateLimitWindow)
+	}
+	c.Prompts = append(c.Prompts, now)
+	return nil
+}
+
+// record counts the response to an unlock request of the caller. It reports
+// whether the user has been locked out by it.
+func (l *unlockLimits) record(userID string, caller string, response string, now time.Time) bool {
+	c := l.caller(caller)
+	u := l.user(userID)
+	switch response {
+	case responseUnlocked:
+		c.Failures, c.LastFailure = 0, time.Time{}
+		u.Failures = 0
+	case responseCanceled:
+		c.Failures++
+		c.LastFailure = now
+	case responseDenied, responseTimedOut:
+		c.Failures++
+		c.LastFailure = now
+		u.Failures++
+		if u.Failures >= lockoutFailures && u.LockedOutAt == nil {
+			u.LockedOutAt = &now
+			return true
+		}
+	}
+	return false
+}
+
+// prune drops the callers that neither prompted within the rate limit
+// window nor failed within the maximum backoff, and the users without
+// failures, so that the limits don't grow with every caller ever seen.
+func (l *unlockLimits) prune(now time.Time) {
+	for name, c := range l.Callers {
+		recent := false
+		for _, t := range c.Prompts {
+			if now.Sub(t) < rateLimitWindow {
+				recent = true
+				break
+			}
+		}
+		if !recent && now.Sub(c.LastFailure) >= backoffMax {
+			delete(l.Callers, name)
+		}
+	}
+	for userID, u := range l.Users {
+		if u.Failures == 0 && u.LockedOutAt == nil {
+			delete(l.Users, userID)
+		}
+	}
+}
+
+// reset lifts the lockout of the users, all users if none are given, and the
+// backoff of all callers. It returns the users whose lockout was lifted.
+func (l *unlockLimits) reset(userIDs []string) []string {
+	if len(userIDs) == 0 {
+		for userID := range l.Users {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	var lifted []string
+	for _, userID := range userIDs {
+		if u, ok := l.Users[userID]; ok {
+			if u.LockedOutAt != nil {
+				lifted = append(lifted, userID)
+			}
+			delete(l.Users, userID)
+		}
+	}
+	l.Callers = make(map[string]*callerLimit)
+	return lifted
+}
+
+// checkUnlockLimits records a prompt of the caller for the user, and returns
+// the response refusing it if it isn't allowed.
+func checkUnlockLimits(userID string, caller string) string {
+	if !unlockLimitsEnabled {
+		return ""
+	}
+	var allowErr error
+	err := updateLimits(func(l *unlockLimits) {
+		allowErr = l.allow(userID, caller, time.Now())
+	})
+	if err != nil {
+		logging.Errorf("Could not read the unlock limits: %v", err)
+		return responseNotSupported
+	}
+	if allowErr != nil {
+		logging.Errorf("Refusing to unlock for user %s from %s: %v", userID, caller, allowErr)
+		if errors.Is(allowErr, errLockedOut) {
+			return responseLockedOut
+		}
+		return responseRateLimited
+	}
+	return ""
+}
+
+// recordUnlockResult counts the response to an unlock request, and tells the
+// user when it locked them out.
+func recordUnlockResult(store secret.SecretStore, userID string, caller string, response string) {
+	if !unlockLimitsEnabled {
+		return
+	}
+	var lockedOut bool
+	err := updateLimits(func(l *unlockLimits) {
+		lockedOut = l.record(userID, caller, response, time.Now())
+	})
+	if err != nil {
+		logging.Errorf("Could not save the unlock limits: %v", err)
+	}
+	if !lockedOut {
+		return
+	}
+	logging.Errorf("Locked out user %s after %d failed authentications", userID, lockoutFailures)
+	name := firstNonEmpty(accountEmail(store, userID), userID)
+	body := fmt.Sprintf("Biometric unlock of %s was locked after %d failed attempts. Run \"bw-bio-handler unlock-reset\" or enroll again to use it again.", name, lockoutFailures)
+	if err := sendNotification("Biometric unlock locked", body); err != nil {
+		logging.Errorf("Could not send notification: %v", err)
+	}
+}
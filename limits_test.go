@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/biometrics"
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+func TestUnlockLimitsRate(t *testing.T) {
+	l := &unlockLimits{Callers: map[string]*callerLimit{}, Users: map[string]*userLimit{}}
+	now := time.Now()
+	for i := 0; i < rateLimitPrompts; i++ {
+		if err := l.allow("user", "app", now); err != nil {
+			t.Fatalf("prompt %d: %v", i, err)
+		}
+		l.record("user", "app", responseUnlocked, now)
+	}
+	if err := l.allow("user", "app", now); !errors.Is(err, errRateLimited) {
+		t.Fatalf("allow() = %v, want rate limited", err)
+	}
+	if err := l.allow("user", "other", now); err != nil {
+		t.Fatalf("other caller: %v", err)
+	}
+	if err := l.allow("user", "app", now.Add(rateLimitWindow)); err != nil {
+		t.Fatalf("after the window: %v", err)
+	}
+}
+
+func TestUnlockLimitsBackoff(t *testing.T) {
+	l := &unlockLimits{Callers: map[string]*callerLimit{}, Users: map[string]*userLimit{}}
+	now := time.Now()
+	wantBackoff := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for i, want := range wantBackoff {
+		if err := l.allow("user", "app", now); err != nil {
+			t.Fatalf("failure %d: %v", i, err)
+		}
+		l.record("user", "app", responseDenied, now)
+		if err := l.allow("user", "app", now.Add(want-time.Millisecond)); !errors.Is(err, errRateLimited) {
+			t.Fatalf("failure %d: allow() before %s = %v, want rate limited", i, want, err)
+		}
+		now = now.Add(want)
+	}
+	l.Callers["app"].Failures = 100
+	if got := l.Callers["app"].backoff(); got != backoffMax {
+		t.Errorf("backoff() = %s, want %s", got, backoffMax)
+	}
+	l.record("user", "app", responseUnlocked, now)
+	if err := l.allow("user", "app", now); err != nil {
+		t.Fatalf("after unlocking: %v", err)
+	}
+}
+
+func TestUnlockLimitsCanceled(t *testing.T) {
+	l := &unlockLimits{Callers: map[string]*callerLimit{}, Users: map[string]*userLimit{}}
+	now := time.Now()
+	for i := 0; i < 2*lockoutFailures; i++ {
+		if l.record("user", "app", responseCanceled, now) {
+			t.Fatalf("locked out after %d canceled prompts", i+1)
+		}
+	}
+	if err := l.allow("user", "app", now); !errors.Is(err, errRateLimited) {
+		t.Errorf("allow() after canceled prompts = %v, want rate limited", err)
+	}
+	if err := l.allow("user", "other", now); err != nil {
+		t.Errorf("allow() of another caller = %v", err)
+	}
+}
+
+func TestUnlockLimitsPrune(t *testing.T) {
+	l := &unlockLimits{Callers: map[string]*callerLimit{}, Users: map[string]*userLimit{}}
+	now := time.Now()
+	l.record("user", "failed", responseDenied, now.Add(-backoffMax))
+	l.record("user", "waiting", responseDenied, now.Add(-time.Second))
+	if err := l.allow("unlocked", "prompted", now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	l.record("unlocked", "prompted", responseUnlocked, now)
+	l.prune(now)
+	if len(l.Callers) != 2 || l.Callers["waiting"] == nil || l.Callers["prompted"] == nil {
+		t.Errorf("callers after pruning: %v", l.Callers)
+	}
+	if len(l.Users) != 1 || l.Users["user"].Failures != 2 {
+		t.Errorf("users after pruning: %v", l.Users)
+	}
+}
+
+func TestUnlockKeyBackoff(t *testing.T) {
+	store := pinStore(t, 3)
+	if response, _ := unlockKey(store, "user", "app", nil, answers()); response != responseCanceled {
+		t.Fatalf("unlockKey() = %q, want canceled", response)
+	}
+	// The caller has to wait after canceling, whatever app id it sends.
+	asked := false
+	ask := func(string, string) (string, error) {
+		asked = true
+		return "1234", nil
+	}
+	if response, _ := unlockKey(store, "user", "other", nil, ask); response != responseRateLimited || asked {
+		t.Fatalf("unlockKey() = %q, asked for the PIN: %v, want rate limited", response, asked)
+	}
+}
+
+func TestUnlockKeyLockout(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	send := sendNotification
+	defer func() { sendNotification = send }()
+	notified := 0
+	sendNotification = func(summary string, body string) error {
+		notified++
+		return nil
+	}
+
+	store := secret.NewMemorySecretStore()
+	if err := store.SetSecret("user", "key"); err != nil {
+		t.Fatal(err)
+	}
+	// Failures of other callers count towards the lockout of the user.
+	err := updateLimits(func(l *unlockLimits) {
+		for i := 0; i < lockoutFailures-1; i++ {
+			l.record("user", "other", responseDenied, time.Now().Add(-time.Hour))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	auth := biometrics.AuthenticatorFunc(func(ctx context.Context, req biometrics.Request) (biometrics.Result, error) {
+		calls++
+		return biometrics.Denied, nil
+	})
+
+	if response, _ := unlockKey(store, "user", "app", auth, answers()); response != responseDenied {
+		t.Fatalf("unlockKey() = %q, want denied", response)
+	}
+	if notified != 1 {
+		t.Errorf("notified %d times of the lockout, want 1", notified)
+	}
+	if response, _ := unlockKey(store, "user", "new", auth, answers()); response != responseLockedOut {
+		t.Fatalf("unlockKey() = %q, want locked out", response)
+	}
+	if calls != 1 {
+		t.Errorf("authenticated %d times, want 1", calls)
+	}
+
+	// The lockout survives restarts, it is only lifted by unlock-reset.
+	p := newPrinter(true)
+	res := &unlockResetResult{}
+	if err := unlockReset(p, &changeSet{p: p, dryRun: true}, nil, res); err != nil {
+		t.Fatal(err)
+	}
+	if response, _ := unlockKey(store, "user", "new", auth, answers()); response != responseLockedOut {
+		t.Fatalf("unlockKey() after dry run = %q, want locked out", response)
+	}
+	res = &unlockResetResult{}
+	if err := unlockReset(p, &changeSet{p: p}, []string{"user"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Lifted) != 1 || res.Lifted[0] != "user" {
+		t.Errorf("lifted %v, want [user]", res.Lifted)
+	}
+	if response, _ := unlockKey(store, "user", "app", auth, answers()); response != responseDenied {
+		t.Fatalf("unlockKey() after reset = %q, want denied", response)
+	}
+}
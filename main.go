@@ -1,140 +1,82 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
 
+	"github.com/quexten/bw-bio-handler/biometrics"
 	"github.com/quexten/bw-bio-handler/logging"
-	"github.com/quexten/bw-bio-handler/pkg/bitw"
 	"github.com/quexten/bw-bio-handler/secret"
+	"github.com/quexten/bw-bio-handler/session"
 )
 
 const appID = "com.quexten.bw-bio-handler"
 
 var transportKey []byte
 var secretStore secret.SecretStore
+var authenticator biometrics.Authenticator
 
 func main() {
-	if os.Args[1] == "install" {
-		install()
-		return
+	// Browsers start the handler with the manifest path or the extension
+	// origin as arguments, so anything that isn't a known command runs the
+	// native messaging handler.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "install":
+			os.Exit(runInstall(os.Args[2:]))
+		case "uninstall":
+			os.Exit(runUninstall(os.Args[2:]))
+		case "enroll", "rekey":
+			os.Exit(runEnroll(os.Args[2:]))
+		case "accounts":
+			os.Exit(runAccounts(os.Args[2:]))
+		case "switch":
+			os.Exit(runSwitch(os.Args[2:]))
+		case "revoke":
+			os.Exit(runRevoke(os.Args[2:]))
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "unlock-reset":
+			os.Exit(runUnlockReset(os.Args[2:]))
+		}
 	}
 
-	s, err := secret.GetStore()
+	cfg, err := loadConfig()
 	if err != nil {
-		logging.Panicf(err.Error())
+		fatalf("could not load config: %v", err)
 	}
-	secretStore = s
-
-	transportKey = generateTransportKey()
-
-	setupCommunication()
-	readLoop()
-}
-
-func install() {
-	fmt.Println("Installing...")
-	fmt.Println("Copying polkit policy...")
-	workdir := os.Getenv("PWD")
-	cmd := exec.Command("pkexec", "cp", workdir+"/biometrics/policies/com.quexten.bw-bio-handler.policy", "/usr/share/polkit-1/actions/")
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	_ = cmd.Run()
-
-	// check file exists
-	_, err := os.Stat("/usr/share/polkit-1/actions/com.quexten.bw-bio-handler.policy")
+	s, backend, err := openSecretStore(cfg)
 	if err != nil {
-		logging.Panicf("Failed to copy polkit policy: %s", err.Error())
+		fatalf("%v", err)
 	}
-
-	fmt.Println("Detecting browsers...")
-	err = detectAndInstallBrowsers(".config")
+	logging.Debugf("Using secret backend %s", backend)
+	secretStore = s
+	authenticator, err = newAuthenticator(cfg)
 	if err != nil {
-		panic("Failed to detect browsers: " + err.Error())
+		fatalf("%v", err)
 	}
-	err = detectAndInstallBrowsers(".mozilla")
+	gracePeriod, err = parseGracePeriod(firstNonEmpty(os.Getenv("BW_BIO_GRACE_PERIOD"), cfg.gracePeriod))
 	if err != nil {
-		panic("Failed to detect browsers: " + err.Error())
+		fatalf("%v", err)
 	}
-
-	fmt.Println("Getting secret...")
-
-	// read email from command line
-	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Print("Enter email: ")
-	scanner.Scan()
-	email := scanner.Text()
-
-	fmt.Println("Enter password: ")
-	scanner.Scan()
-	password := scanner.Text()
-
-	fmt.Println("Enter api url (leave empty for default): ")
-	scanner.Scan()
-	apiURL := scanner.Text()
-	if apiURL == "" {
-		apiURL = "https://api.bitwarden.com"
+	// A grace period that a screen lock or sleep doesn't end would keep
+	// unlocking a locked machine.
+	if gracePeriod > 0 && !session.CanWatchLock() {
+		fatalf("grace periods are not supported on this platform, as the screen lock can't be watched")
 	}
+	watchGracePeriods()
 
-	fmt.Println("Enter identity url (leave empty for default): ")
-	scanner.Scan()
-	idtURL := scanner.Text()
-	if idtURL == "" {
-		idtURL = "https://identity.bitwarden.com"
-	}
-
-	err = bitw.DoLogin(email, password, apiURL, idtURL)
-	if err != nil {
-		panic("Failed to login: " + err.Error())
-	}
-	encKey := bitw.GetEncKeyB64()
-	userID := bitw.GetUserID()
-	fmt.Println("Got secret!")
-
-	fmt.Println("Storing in libsecret...")
-	store, err := secret.GetStore()
-	if err != nil {
-		panic("Failed to get secret store: " + err.Error())
-	}
-	store.SetSecret(userID, encKey)
+	transportKey = generateTransportKey()
 
-	fmt.Println("Done!")
-	fmt.Println("You can now activate the biometrics support in your browser. Enjoy!")
+	setupCommunication()
+	readLoop()
 }
 
-func detectAndInstallBrowsers(startPath string) error {
-	home := os.Getenv("HOME")
-	err := filepath.Walk(home+"/"+startPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		var tempPath string
-		if !strings.HasPrefix(path, home) {
-			return nil
-		} else {
-			tempPath = strings.TrimPrefix(path, home)
-		}
-		if strings.Count(tempPath, "/") > 3 {
-			return nil
-		}
-
-		if info.IsDir() && info.Name() == "native-messaging-hosts" {
-			fmt.Printf("Found mozilla-like browser: %s\n", path)
-			manifest := strings.Replace(templateMozilla, "PATH", os.Getenv("PWD")+"/bw-bio-handler", 1)
-			err = os.WriteFile(path+"/com.8bit.bitwarden.json", []byte(manifest), 0644)
-		} else if info.IsDir() && info.Name() == "NativeMessagingHosts" {
-			fmt.Printf("Found chrome-like browser: %s\n", path)
-			manifest := strings.Replace(templateChrome, "PATH", os.Getenv("PWD")+"/bw-bio-handler", 1)
-			err = os.WriteFile(path+"/com.8bit.bitwarden.json", []byte(manifest), 0644)
-		}
-
-		return err
-	})
-
-	return err
+// fatalf reports an error that keeps the handler from starting and exits.
+// It is printed to stderr, which browsers log, as the debug log is only
+// written by builds with the logging tag.
+func fatalf(format string, args ...interface{}) {
+	logging.Errorf(format, args...)
+	fmt.Fprintf(os.Stderr, "bw-bio-handler: "+format+"\n", args...)
+	os.Exit(1)
 }
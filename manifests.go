@@ -1,23 +1,174 @@
 package main
 
-const templateMozilla = `{
-    "name": "com.8bit.bitwarden",
-    "description": "Bitwarden desktop <-> browser bridge",
-    "path": "PATH",
-    "type": "stdio",
-    "allowed_extensions": [
-      "{446900e4-71c2-419f-a6a7-df9c091e268b}"
-    ]
-}`
-
-const templateChrome = `{
-	"name": "com.8bit.bitwarden",
-	"description": "Bitwarden desktop <-> browser bridge",
-	"path": "PATH",
-	"type": "stdio",
-	"allowed_origins": [
-	  "chrome-extension://nngceckbapebfimnlniiiahkandclblb/",
-	  "chrome-extension://jbkfoedolllekgbhcbcoahefnbanhhlh/",
-	  "chrome-extension://ccnckbpmaceehanjmeomladnmlffdjgn/"
-	]
-  }`
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultHostName    = "com.8bit.bitwarden"
+	defaultDescription = "Bitwarden desktop <-> browser bridge"
+)
+
+// The extension IDs of the official Bitwarden extensions in the Chrome Web
+// Store, the Edge Add-ons store and the Opera add-ons store, and on
+// addons.mozilla.org.
+var (
+	defaultChromeExtensions  = []string{"nngceckbapebfimnlniiiahkandclblb", "jbkfoedolllekgbhcbcoahefnbanhhlh", "ccnckbpmaceehanjmeomladnmlffdjgn"}
+	defaultMozillaExtensions = []string{"{446900e4-71c2-419f-a6a7-df9c091e268b}"}
+)
+
+var (
+	// Chrome only allows lowercase alphanumerics, underscores and single
+	// dots, Firefox additionally allows uppercase letters.
+	chromeHostNameRegexp  = regexp.MustCompile(`^[a-z0-9_]+(\.[a-z0-9_]+)*$`)
+	mozillaHostNameRegexp = regexp.MustCompile(`^\w+(\.\w+)*$`)
+
+	// Chrome extension IDs are 32 characters in the range a-p.
+	chromeExtensionRegexp = regexp.MustCompile(`^[a-p]{32}$`)
+	// Firefox extension IDs are either a braced GUID or email-like.
+	mozillaGUIDRegexp  = regexp.MustCompile(`^\{[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\}$`)
+	mozillaEmailRegexp = regexp.MustCompile(`^[a-zA-Z0-9-._]*@[a-zA-Z0-9-._]+$`)
+)
+
+type chromeManifest struct {
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	Path           string   `json:"path"`
+	Type           string   `json:"type"`
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+type mozillaManifest struct {
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Path              string   `json:"path"`
+	Type              string   `json:"type"`
+	AllowedExtensions []string `json:"allowed_extensions"`
+}
+
+// manifestOptions configures the generated native messaging manifests.
+type manifestOptions struct {
+	hostName          string
+	description       string
+	path              string
+	chromeExtensions  []string
+	mozillaExtensions []string
+}
+
+func defaultManifestOptions(path string) *manifestOptions {
+	return &manifestOptions{
+		hostName:          defaultHostName,
+		description:       defaultDescription,
+		path:              path,
+		chromeExtensions:  defaultChromeExtensions,
+		mozillaExtensions: defaultMozillaExtensions,
+	}
+}
+
+// manifestFlags are the command line overrides of the manifest options.
+type manifestFlags struct {
+	hostName          string
+	description       string
+	chromeExtensions  string
+	mozillaExtensions string
+}
+
+func (f *manifestFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.hostName, "host-name", "", "native messaging host name (default "+defaultHostName+")")
+	fs.StringVar(&f.description, "description", "", "manifest description")
+	fs.StringVar(&f.chromeExtensions, "chrome-extensions", "", "comma separated chrome extension ids to allow (default: the official store ids)")
+	fs.StringVar(&f.mozillaExtensions, "mozilla-extensions", "", "comma separated mozilla extension ids to allow (default: the official store id)")
+}
+
+// resolve merges the flags with the config file on top of the defaults.
+func (f *manifestFlags) resolve(cfg *config, path string) *manifestOptions {
+	o := defaultManifestOptions(path)
+	o.hostName = firstNonEmpty(f.hostName, cfg.hostName, o.hostName)
+	o.description = firstNonEmpty(f.description, cfg.description, o.description)
+	if list := splitList(f.chromeExtensions); len(list) > 0 {
+		o.chromeExtensions = list
+	} else if len(cfg.chromeExtensions) > 0 {
+		o.chromeExtensions = cfg.chromeExtensions
+	}
+	if list := splitList(f.mozillaExtensions); len(list) > 0 {
+		o.mozillaExtensions = list
+	} else if len(cfg.mozillaExtensions) > 0 {
+		o.mozillaExtensions = cfg.mozillaExtensions
+	}
+	return o
+}
+
+// fileName is the name browsers expect the manifest file to have.
+func (o *manifestOptions) fileName() string {
+	return o.hostName + ".json"
+}
+
+// manifest generates and validates the manifest for the given flavour.
+func (o *manifestOptions) manifest(flavour manifestFlavour) ([]byte, error) {
+	if !filepath.IsAbs(o.path) {
+		return nil, fmt.Errorf("manifest path must be absolute: %q", o.path)
+	}
+
+	var manifest interface{}
+	switch flavour {
+	case flavourChrome:
+		if len(o.chromeExtensions) == 0 {
+			return nil, fmt.Errorf("no allowed chrome extensions")
+		}
+		if !chromeHostNameRegexp.MatchString(o.hostName) {
+			return nil, fmt.Errorf("invalid host name for chrome-like browsers: %q", o.hostName)
+		}
+		origins := make([]string, len(o.chromeExtensions))
+		for i, id := range o.chromeExtensions {
+			id = strings.TrimSuffix(strings.TrimPrefix(id, "chrome-extension://"), "/")
+			if !chromeExtensionRegexp.MatchString(id) {
+				return nil, fmt.Errorf("invalid chrome extension id: %q", id)
+			}
+			origins[i] = "chrome-extension://" + id + "/"
+		}
+		manifest = chromeManifest{
+			Name:           o.hostName,
+			Description:    o.description,
+			Path:           o.path,
+			Type:           "stdio",
+			AllowedOrigins: origins,
+		}
+	case flavourMozilla:
+		if len(o.mozillaExtensions) == 0 {
+			return nil, fmt.Errorf("no allowed mozilla extensions")
+		}
+		if !mozillaHostNameRegexp.MatchString(o.hostName) {
+			return nil, fmt.Errorf("invalid host name for mozilla-like browsers: %q", o.hostName)
+		}
+		for _, id := range o.mozillaExtensions {
+			if !mozillaGUIDRegexp.MatchString(id) && !mozillaEmailRegexp.MatchString(id) {
+				return nil, fmt.Errorf("invalid mozilla extension id: %q", id)
+			}
+		}
+		manifest = mozillaManifest{
+			Name:              o.hostName,
+			Description:       o.description,
+			Path:              o.path,
+			Type:              "stdio",
+			AllowedExtensions: o.mozillaExtensions,
+		}
+	default:
+		return nil, fmt.Errorf("unknown manifest flavour %d", flavour)
+	}
+
+	// The default description contains "<->", which must not be escaped.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestManifestDefaults(t *testing.T) {
+	opts := defaultManifestOptions("/usr/bin/bw-bio-handler")
+
+	bs, err := opts.manifest(flavourChrome)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chrome chromeManifest
+	if err := json.Unmarshal(bs, &chrome); err != nil {
+		t.Fatal(err)
+	}
+	if chrome.Name != defaultHostName || chrome.Path != "/usr/bin/bw-bio-handler" || chrome.Type != "stdio" {
+		t.Fatalf("unexpected chrome manifest: %+v", chrome)
+	}
+	if chrome.AllowedOrigins[0] != "chrome-extension://nngceckbapebfimnlniiiahkandclblb/" {
+		t.Fatalf("unexpected allowed origin: %q", chrome.AllowedOrigins[0])
+	}
+
+	bs, err = opts.manifest(flavourMozilla)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mozilla mozillaManifest
+	if err := json.Unmarshal(bs, &mozilla); err != nil {
+		t.Fatal(err)
+	}
+	if len(mozilla.AllowedExtensions) != 1 || mozilla.AllowedExtensions[0] != defaultMozillaExtensions[0] {
+		t.Fatalf("unexpected allowed extensions: %v", mozilla.AllowedExtensions)
+	}
+}
+
+func TestManifestValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(o *manifestOptions)
+		flavour manifestFlavour
+		valid   bool
+	}{
+		{"custom chrome origin", func(o *manifestOptions) {
+			o.chromeExtensions = []string{"chrome-extension://abcdefghijklmnopabcdefghijklmnop/"}
+		}, flavourChrome, true},
+		{"chrome id out of range", func(o *manifestOptions) {
+			o.chromeExtensions = []string{"zbcdefghijklmnopabcdefghijklmnop"}
+		}, flavourChrome, false},
+		{"chrome id too short", func(o *manifestOptions) {
+			o.chromeExtensions = []string{"abcdef"}
+		}, flavourChrome, false},
+		{"no chrome ids", func(o *manifestOptions) {
+			o.chromeExtensions = nil
+		}, flavourChrome, false},
+		{"uppercase chrome host name", func(o *manifestOptions) {
+			o.hostName = "com.Example.host"
+		}, flavourChrome, false},
+		{"uppercase mozilla host name", func(o *manifestOptions) {
+			o.hostName = "com.Example.host"
+		}, flavourMozilla, true},
+		{"double dot host name", func(o *manifestOptions) {
+			o.hostName = "com..example"
+		}, flavourMozilla, false},
+		{"email-like mozilla id", func(o *manifestOptions) {
+			o.mozillaExtensions = []string{"bitwarden-beta@example.com"}
+		}, flavourMozilla, true},
+		{"malformed mozilla guid", func(o *manifestOptions) {
+			o.mozillaExtensions = []string{"{446900e4-71c2-419f}"}
+		}, flavourMozilla, false},
+		{"relative path", func(o *manifestOptions) {
+			o.path = "bw-bio-handler"
+		}, flavourChrome, false},
+	}
+
+	for _, test := range tests {
+		opts := defaultManifestOptions("/usr/bin/bw-bio-handler")
+		test.modify(opts)
+		_, err := opts.manifest(test.flavour)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
@@ -20,7 +20,7 @@ type ReceiveMessage struct {
 	Timestamp int64  `json:"timestamp"`
 	Command   string `json:"command"`
 	Response  string `json:"response"`
-	KeyB64    string `json:"keyB64"`
+	KeyB64    string `json:"keyB64,omitempty"`
 }
 
 type SendMessage struct {
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+type migrateResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	DryRun bool   `json:"dryRun,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	// Accounts are the user IDs whose keys were migrated.
+	Accounts []string `json:"accounts,omitempty"`
+	// Entries counts the migrated secret store entries: keys, browser copies
+	// and the account index.
+	Entries       int      `json:"entries,omitempty"`
+	SourceDeleted bool     `json:"sourceDeleted,omitempty"`
+	Changes       []change `json:"changes,omitempty"`
+}
+
+// runMigrate copies the enrolled accounts from one secret backend to
+// another, so that switching backends doesn't need a new install.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := fs.String("from", "", "secret backend to copy the keys from ("+strings.Join(secret.Backends(), ", ")+"); defaults to the one in use")
+	to := fs.String("to", "", "secret backend to copy the keys to")
+	deleteSource := fs.Bool("delete-source", false, "delete the keys from the source backend once all copies are verified")
+	dryRun := fs.Bool("dry-run", false, "only print what would be changed")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *to == "" || fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: bw-bio-handler migrate [--from backend] --to backend [flags]")
+		fs.PrintDefaults()
+		return 2
+	}
+
+	p := newPrinter(*jsonOutput)
+	changes := &changeSet{p: p, dryRun: *dryRun}
+	res := &migrateResult{Status: "ok", DryRun: *dryRun}
+	err := migrate(p, changes, *from, *to, *deleteSource, res)
+	res.Changes = changes.changes
+	if err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+		p.result(res)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	p.result(res)
+	return 0
+}
+
+func migrate(p *printer, changes *changeSet, fromName string, toName string, deleteSource bool, res *migrateResult) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("could not load config: %v", err)
+	}
+	var from secret.SecretStore
+	if fromName == "" {
+		from, fromName, err = openSecretStore(cfg)
+	} else {
+		from, err = secret.OpenBackend(fromName, secretOptions(cfg))
+	}
+	if err != nil {
+		return err
+	}
+	if fromName == toName {
+		return fmt.Errorf("the keys are already stored in %s", toName)
+	}
+	to, err := secret.OpenBackend(toName, secretOptions(cfg))
+	if err != nil {
+		return err
+	}
+	res.From = fromName
+	res.To = toName
+
+	p.Printf("Migrating the keys from %s to %s...\n", fromName, toName)
+	if err := migrateStores(p, changes, from, to, deleteSource, res); err != nil {
+		return err
+	}
+
+	if changes.dryRun {
+		p.Println("Dry run, nothing was changed.")
+		return nil
+	}
+	if _, current, err := openSecretStore(cfg); err != nil || current != toName {
+		p.Printf("Set BW_BIO_SECRET_BACKEND=%s, or secretbackend = %s in the config file, so that the handler uses the migrated keys.\n", toName, toName)
+	}
+	p.Println("Done!")
+	return nil
+}
+
+// migrationEntry is a secret store entry to migrate. meta describes it for
+// sources that don't keep metadata.
+type migrationEntry struct {
+	id   string
+	meta secret.Metadata
+}
+
+// migrateStores copies the keys of the enrolled accounts, their browser
+// copies, the keys wrapping the copies and the account index along with
+// their metadata, and verifies every copy by reading it back. The source
+// entries are only deleted once all copies have been verified.
+func migrateStores(p *printer, changes *changeSet, from secret.SecretStore, to secret.SecretStore, deleteSource bool, res *migrateResult) error {
+	idx, err := loadAccountIndex(from)
+	if err != nil {
+		return err
+	}
+	if len(idx.Accounts) == 0 {
+		return errors.New("no enrolled accounts found, enroll them in the new backend instead")
+	}
+	ps, err := loadPairings()
+	if err != nil {
+		return err
+	}
+
+	var migrated []string
+	for _, a := range idx.Accounts {
+		entries := []migrationEntry{{id: a.UserID, meta: accountMetadata(a)}}
+		for i := range ps.Browsers {
+			b := &ps.Browsers[i]
+			entries = append(entries, migrationEntry{id: copyID(a.UserID, b.AppID), meta: copyMetadata(accountMetadata(a), b)})
+		}
+
+		copied := 0
+		for i, entry := range entries {
+			ok, err := migrateEntry(changes, from, to, entry)
+			if err != nil {
+				return fmt.Errorf("failed to migrate the key of %s: %v", a.Email, err)
+			}
+			if i == 0 && !ok {
+				p.Printf("Skipping %s, its key is not stored.\n", a.Email)
+				break
+			}
+			if ok {
+				migrated = append(migrated, entry.id)
+				copied++
+			}
+		}
+		if copied > 0 {
+			p.Printf("Migrated %s (%d entries).\n", a.Email, copied)
+			res.Accounts = append(res.Accounts, a.UserID)
+		}
+	}
+	for i := range ps.Browsers {
+		b := &ps.Browsers[i]
+		entry := migrationEntry{id: browserKeyID(b.AppID), meta: browserKeyMetadata(b)}
+		ok, err := migrateEntry(changes, from, to, entry)
+		if err != nil {
+			return fmt.Errorf("failed to migrate the key of %s: %v", b.Browser, err)
+		}
+		if ok {
+			migrated = append(migrated, entry.id)
+		}
+	}
+	if _, err := migrateEntry(changes, from, to, migrationEntry{id: accountIndexID, meta: secret.Metadata{Label: "bw-bio-handler account index"}}); err != nil {
+		return fmt.Errorf("failed to migrate the account index: %v", err)
+	}
+	migrated = append(migrated, accountIndexID)
+	res.Entries = len(migrated)
+
+	if !deleteSource {
+		return nil
+	}
+	p.Println("Deleting the keys from the source...")
+	for _, id := range migrated {
+		if err := changes.deleteSecret(from, id); err != nil {
+			return fmt.Errorf("failed to delete %s from the source: %v", id, err)
+		}
+	}
+	res.SourceDeleted = true
+	return nil
+}
+
+// migrateEntry copies the entry, if it exists, and verifies the copy. It
+// reports whether the entry was found.
+func migrateEntry(changes *changeSet, from secret.SecretStore, to secret.SecretStore, entry migrationEntry) (bool, error) {
+	value, err := from.GetSecret(entry.id)
+	if errors.Is(err, secret.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	meta, err := secret.GetMetadata(from, entry.id)
+	if err != nil {
+		return true, err
+	}
+	if meta.Label == "" {
+		meta = entry.meta
+	}
+	if err := changes.setSecret(to, entry.id, value, meta); err != nil {
+		return true, err
+	}
+	if changes.dryRun {
+		return true, nil
+	}
+	copied, err := to.GetSecret(entry.id)
+	if err != nil {
+		return true, fmt.Errorf("could not verify the copy: %v", err)
+	}
+	if copied != value {
+		return true, errors.New("the copy differs from the original")
+	}
+	return true, nil
+}
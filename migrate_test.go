@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+func TestMigrateStores(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	ps := &pairings{Browsers: []pairedBrowser{{AppID: "app", Browser: "firefox", Executable: "/usr/bin/firefox"}}}
+	if err := ps.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	from := secret.NewMemorySecretStore()
+	idx := &accountIndex{Accounts: []account{
+		{UserID: "user", Email: "user@example.com", APIURL: defaultAPIURL, IdentityURL: defaultIdentityURL, EnrolledAt: time.Now()},
+		{UserID: "wiped", Email: "wiped@example.com", APIURL: defaultAPIURL, IdentityURL: defaultIdentityURL, EnrolledAt: time.Now()},
+	}}
+	p := newPrinter(true)
+	if err := idx.save(&changeSet{p: p}, from, ""); err != nil {
+		t.Fatal(err)
+	}
+	meta := secret.Metadata{Label: "Bitwarden key of user@example.com", Attributes: map[string]string{"kdf": "argon2id"}}
+	if err := from.SetSecretWithMetadata("user", "key", meta); err != nil {
+		t.Fatal(err)
+	}
+	// The copy has no metadata, which is then derived from the account.
+	if err := from.SetSecret(copyID("user", "app"), "copy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.SetSecret(browserKeyID("app"), "wrapping key"); err != nil {
+		t.Fatal(err)
+	}
+
+	to := secret.NewMemorySecretStore()
+	res := &migrateResult{}
+	if err := migrateStores(p, &changeSet{p: p}, from, to, true, res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Accounts) != 1 || res.Accounts[0] != "user" || res.Entries != 4 {
+		t.Fatalf("Migrated %v in %d entries, want user in 4", res.Accounts, res.Entries)
+	}
+
+	for id, want := range map[string]string{"user": "key", copyID("user", "app"): "copy", browserKeyID("app"): "wrapping key"} {
+		if got, err := to.GetSecret(id); err != nil || got != want {
+			t.Errorf("GetSecret(%q) = %q, %v after migrating, want %q", id, got, err, want)
+		}
+		if _, err := from.GetSecret(id); !errors.Is(err, secret.ErrNotFound) {
+			t.Errorf("%s not deleted from the source: %v", id, err)
+		}
+	}
+	got, err := to.GetMetadata("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Label != meta.Label || got.Attributes["kdf"] != "argon2id" {
+		t.Errorf("Metadata not migrated: %+v", got)
+	}
+	got, err = to.GetMetadata(copyID("user", "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Attributes["browser"] != "firefox" || got.Attributes["email"] != "user@example.com" {
+		t.Errorf("Metadata of the copy not derived from the account: %+v", got)
+	}
+	migrated, err := loadAccountIndex(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated.Accounts) != 2 {
+		t.Errorf("Account index not migrated: %+v", migrated)
+	}
+}
+
+func TestMigrateStoresDryRun(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	from := secret.NewMemorySecretStore()
+	p := newPrinter(true)
+	idx := &accountIndex{Accounts: []account{{UserID: "user", Email: "user@example.com"}}}
+	if err := idx.save(&changeSet{p: p}, from, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.SetSecret("user", "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	to := secret.NewMemorySecretStore()
+	if err := migrateStores(p, &changeSet{p: p, dryRun: true}, from, to, true, &migrateResult{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := to.GetSecret("user"); !errors.Is(err, secret.ErrNotFound) {
+		t.Errorf("Dry run stored the key: %v", err)
+	}
+	if _, err := from.GetSecret("user"); err != nil {
+		t.Errorf("Dry run deleted the key: %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build linux || freebsd || openbsd || netbsd || dragonfly
+
+package notify
+
+import "github.com/keybase/dbus"
+
+// Send shows a desktop notification through the org.freedesktop.Notifications
+// service of the session bus.
+func Send(summary string, body string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	return conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications").
+		Call("org.freedesktop.Notifications.Notify", 0,
+			"Bitwarden", uint32(0), "dialog-password", summary, body,
+			[]string{}, map[string]dbus.Variant{}, int32(-1)).
+		Err
+}
@@ -0,0 +1,14 @@
+//go:build darwin
+
+package notify
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// Send shows a notification through the Notification Center.
+func Send(summary string, body string) error {
+	script := "display notification " + strconv.Quote(body) + " with title \"Bitwarden\" subtitle " + strconv.Quote(summary)
+	return exec.Command("osascript", "-e", script).Run()
+}
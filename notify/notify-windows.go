@@ -0,0 +1,10 @@
+//go:build windows
+
+package notify
+
+import "errors"
+
+// Send is not implemented on Windows yet.
+func Send(summary string, body string) error {
+	return errors.New("notifications are not implemented on Windows")
+}
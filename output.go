@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// printer writes the progress of a command. In JSON mode the progress goes
+// to stderr, so that stdout only carries the machine-readable result.
+type printer struct {
+	out  io.Writer
+	json bool
+}
+
+func newPrinter(jsonOutput bool) *printer {
+	if jsonOutput {
+		return &printer{out: os.Stderr, json: true}
+	}
+	return &printer{out: os.Stdout}
+}
+
+func (p *printer) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(p.out, format, args...)
+}
+
+func (p *printer) Println(args ...interface{}) {
+	fmt.Fprintln(p.out, args...)
+}
+
+// result prints v as JSON on stdout. It does nothing outside of JSON mode.
+func (p *printer) result(v interface{}) {
+	if !p.json {
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/internal/lockedfile"
+	"github.com/quexten/bw-bio-handler/logging"
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+// pairingsVersion 1 kept the wrapping keys in the file and didn't know the
+// executables of the browsers.
+const pairingsVersion = 2
+
+// maxPairingRequests is the number of pending pairing requests that are
+// kept, as any local process can make them.
+const maxPairingRequests = 16
+
+// pairedBrowser is a browser extension that has been given its own copies of
+// the keys. Extensions are told apart by the app id they send with every
+// message, and the executable of the browser that starts the handler, which
+// unlike the app id can't be picked by another local program. The public key
+// the extension sets up the encryption with can't be used, as it is new on
+// every connection.
+type pairedBrowser struct {
+	AppID   string `json:"appId"`
+	Browser string `json:"browser"`
+	// Executable is the path of the browser, see parentExecutable. Browsers
+	// paired by version 1 have none and have to be paired again.
+	Executable string     `json:"executable,omitempty"`
+	PairedAt   time.Time  `json:"pairedAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+func (b *pairedBrowser) revoked() bool {
+	return b.RevokedAt != nil
+}
+
+// pairingRequest is an extension that asked for a key without being paired.
+// It is only paired when the user picks it with enroll --pair.
+type pairingRequest struct {
+	AppID       string    `json:"appId"`
+	Browser     string    `json:"browser"`
+	Executable  string    `json:"executable"`
+	RequestedAt time.Time `json:"requestedAt"`
+}
+
+// pairings lists the paired browsers, including the revoked ones so that
+// they aren't paired again, and the pending pairing requests.
+type pairings struct {
+	Version  int              `json:"version"`
+	Browsers []pairedBrowser  `json:"browsers"`
+	Requests []pairingRequest `json:"requests,omitempty"`
+}
+
+func pairingsPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "browsers.json"), nil
+}
+
+// lockPairings serializes changes of the pairings with other handlers. The
+// returned function releases the lock.
+func lockPairings() (func(), error) {
+	path, err := pairingsPath()
+	if err != nil {
+		return nil, err
+	}
+	return lockedfile.Lock(path)
+}
+
+func loadPairings() (*pairings, error) {
+	ps := &pairings{Version: pairingsVersion}
+	path, err := pairingsPath()
+	if err != nil {
+		return nil, err
+	}
+	bs, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ps, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, ps); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	if ps.Version > pairingsVersion {
+		return nil, fmt.Errorf("paired browsers version %d is newer than supported version %d", ps.Version, pairingsVersion)
+	}
+	return ps, nil
+}
+
+// save writes the pairings atomically, as several handlers may run at once.
+// The keys of version 1 are dropped by it.
+func (ps *pairings) save() error {
+	path, err := pairingsPath()
+	if err != nil {
+		return err
+	}
+	ps.Version = pairingsVersion
+	bs, err := json.MarshalIndent(ps, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bs)
+}
+
+// get returns the browser with the app id, or nil.
+func (ps *pairings) get(appID string) *pairedBrowser {
+	for i := range ps.Browsers {
+		if ps.Browsers[i].AppID == appID {
+			return &ps.Browsers[i]
+		}
+	}
+	return nil
+}
+
+// find returns the browser with the given app id, or the only active one
+// with the given name.
+func (ps *pairings) find(query string) (*pairedBrowser, error) {
+	if b := ps.get(query); b != nil {
+		return b, nil
+	}
+	var found *pairedBrowser
+	for i, b := range ps.Browsers {
+		if b.Browser != query || b.revoked() {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%q matches more than one browser, use the app id", query)
+		}
+		found = &ps.Browsers[i]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no paired browser %q", query)
+	}
+	return found, nil
+}
+
+// active returns the browsers that haven't been revoked.
+func (ps *pairings) active() []*pairedBrowser {
+	var active []*pairedBrowser
+	for i := range ps.Browsers {
+		if !ps.Browsers[i].revoked() {
+			active = append(active, &ps.Browsers[i])
+		}
+	}
+	return active
+}
+
+// request records that the extension with the app id and executable asked
+// for a key without being paired, replacing its previous request. The
+// oldest requests are dropped beyond maxPairingRequests.
+func (ps *pairings) request(r pairingRequest) {
+	requests := []pairingRequest{}
+	for _, old := range ps.Requests {
+		if old.AppID != r.AppID {
+			requests = append(requests, old)
+		}
+	}
+	requests = append(requests, r)
+	if len(requests) > maxPairingRequests {
+		requests = requests[len(requests)-maxPairingRequests:]
+	}
+	ps.Requests = requests
+}
+
+// findRequests returns the pending requests to pair for query, a comma
+// separated list of app ids and browser names, or "all". Every element has
+// to match a request.
+func (ps *pairings) findRequests(query string) ([]pairingRequest, error) {
+	var found []pairingRequest
+	for _, q := range splitList(query) {
+		matched := false
+		for _, r := range ps.Requests {
+			if q == "all" || r.AppID == q || r.Browser == q {
+				found = append(found, r)
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no pairing request of %q, start the browser and unlock once to make one", q)
+		}
+	}
+	return found, nil
+}
+
+// pair turns the request into a paired browser, replacing the pairing of the
+// same app id with an outdated executable. Revoked app ids can't be paired
+// again.
+func (ps *pairings) pair(r pairingRequest, now time.Time) (*pairedBrowser, error) {
+	for i, old := range ps.Requests {
+		if old.AppID == r.AppID {
+			ps.Requests = append(ps.Requests[:i], ps.Requests[i+1:]...)
+			break
+		}
+	}
+	paired := pairedBrowser{AppID: r.AppID, Browser: r.Browser, Executable: r.Executable, PairedAt: now}
+	if b := ps.get(r.AppID); b != nil {
+		if b.revoked() {
+			return nil, fmt.Errorf("%s with app id %s has been revoked", b.Browser, b.AppID)
+		}
+		*b = paired
+		return b, nil
+	}
+	ps.Browsers = append(ps.Browsers, paired)
+	return &ps.Browsers[len(ps.Browsers)-1], nil
+}
+
+// copyIDs returns the secret store entries of all browser copies of the key
+// of the user.
+func (ps *pairings) copyIDs(userID string) []string {
+	ids := make([]string, len(ps.Browsers))
+	for i, b := range ps.Browsers {
+		ids[i] = copyID(userID, b.AppID)
+	}
+	return ids
+}
+
+// copyID is the secret store entry of the browser's copy of the key of the
+// user.
+func copyID(userID string, appID string) string {
+	return userID + ":" + appID
+}
+
+// browserKeyID is the secret store entry of the key wrapping the copies of
+// the browser.
+func browserKeyID(appID string) string {
+	return "bw-bio-handler-browser-key:" + appID
+}
+
+// copyAdditionalData binds the browser's copy of the key of the user to its
+// entry and to the executable the browser was paired with.
+func copyAdditionalData(userID string, b *pairedBrowser) string {
+	return copyID(userID, b.AppID) + ":" + b.Executable
+}
+
+// parentExecutable returns the path of the executable that started the
+// handler, usually the browser. It can only be read on Linux, elsewhere it
+// is "unknown" for every browser, which are then told apart by the app id
+// alone. It is replaced in tests.
+var parentExecutable = func() string {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", os.Getppid()))
+	if err != nil {
+		return "unknown"
+	}
+	return exe
+}
+
+// browserKey reads the key wrapping the copies of the browser. It is kept
+// in the secret store, and deleted when the browser is revoked.
+func browserKey(store secret.SecretStore, b *pairedBrowser) ([]byte, error) {
+	value, err := store.GetSecret(browserKeyID(b.AppID))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+// browserKeyMetadata describes the key wrapping the copies of the browser.
+func browserKeyMetadata(b *pairedBrowser) secret.Metadata {
+	return secret.Metadata{
+		Label: "bw-bio-handler key of " + b.Browser + " (" + b.AppID + ")",
+		Attributes: map[string]string{
+			"browser": b.Browser,
+			"app-id":  b.AppID,
+		},
+	}
+}
+
+// wrapCopy wraps the key of the user for the browser.
+func wrapCopy(key []byte, b *pairedBrowser, userID string, value string) (string, error) {
+	if b.revoked() {
+		return "", fmt.Errorf("browser %s (%s) has been revoked", b.Browser, b.AppID)
+	}
+	return secret.Wrap(key, copyAdditionalData(userID, b), value)
+}
+
+// browserCopy reads the browser's copy of the key of the user. A copy that
+// can't be unwrapped, f.e. because the browser was paired again, counts as
+// missing.
+func browserCopy(store secret.SecretStore, userID string, b *pairedBrowser) (string, error) {
+	key, err := browserKey(store, b)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := store.GetSecret(copyID(userID, b.AppID))
+	if err != nil {
+		return "", err
+	}
+	value, err := secret.Unwrap(key, copyAdditionalData(userID, b), wrapped)
+	if errors.Is(err, secret.ErrWrongKey) {
+		logging.Errorf("The copy of the key of user %s for %s (%s) does not match its pairing", userID, b.Browser, b.AppID)
+		return "", secret.ErrNotFound
+	}
+	return value, err
+}
+
+// copyMetadata describes the browser's copy of a key described by meta.
+func copyMetadata(meta secret.Metadata, b *pairedBrowser) secret.Metadata {
+	attributes := map[string]string{
+		"browser": b.Browser,
+		"app-id":  b.AppID,
+	}
+	for k, v := range meta.Attributes {
+		attributes[k] = v
+	}
+	return secret.Metadata{
+		Label:      meta.Label + " for " + b.Browser,
+		Attributes: attributes,
+	}
+}
+
+// requestPairing records a pairing request of the extension with the app id
+// started by the executable, unless it is paired with it or revoked. It is
+// called by the handler when an extension asks for a key it has no copy of.
+func requestPairing(appID string, executable string) {
+	unlock, err := lockPairings()
+	if err != nil {
+		logging.Errorf("Could not record the pairing request of app id %s: %v", appID, err)
+		return
+	}
+	defer unlock()
+	ps, err := loadPairings()
+	if err != nil {
+		logging.Errorf("Could not record the pairing request of app id %s: %v", appID, err)
+		return
+	}
+	if b := ps.get(appID); b != nil && (b.revoked() || b.Executable == executable) {
+		return
+	}
+	ps.request(pairingRequest{AppID: appID, Browser: detectBrowser(), Executable: executable, RequestedAt: time.Now().UTC()})
+	if err := ps.save(); err != nil {
+		logging.Errorf("Could not record the pairing request of app id %s: %v", appID, err)
+		return
+	}
+	logging.Debugf("Recorded a pairing request of app id %s, pair it with enroll --pair", appID)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/biometrics"
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+// pairTestBrowser pairs the browser with the app id, as enroll --pair does
+// after its pairing request, and gives it copies of the keys of the users.
+func pairTestBrowser(t *testing.T, store secret.SecretStore, appID string, userIDs ...string) {
+	t.Helper()
+	requestPairing(appID, parentExecutable())
+	p := newPrinter(true)
+	changes := &changeSet{p: p}
+	ps, newKeys, err := pairBrowsers(p, changes, store, appID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range userIDs {
+		value, err := store.GetSecret(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storeBrowserCopies(p, changes, store, ps, newKeys, &enrollment{userID: userID}, value, secret.Metadata{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUnlockKeyPairing(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	store := secret.NewMemorySecretStore()
+	if err := store.SetSecret("user", "key"); err != nil {
+		t.Fatal(err)
+	}
+	defer func(enabled bool) { unlockLimitsEnabled = enabled }(unlockLimitsEnabled)
+	unlockLimitsEnabled = false
+	defer func(parent func() string) { parentExecutable = parent }(parentExecutable)
+	exe := "/usr/bin/firefox"
+	parentExecutable = func() string { return exe }
+	auth := authResult(biometrics.Granted)
+	unlock := func(appID string) (string, string) {
+		return unlockKey(store, "user", appID, auth, answers())
+	}
+
+	// Until a browser is paired, all of them are given the enrolled key, and
+	// they only make pairing requests.
+	for _, appID := range []string{"app1", "app2"} {
+		if response, key := unlock(appID); response != responseUnlocked || key != "key" {
+			t.Fatalf("unlockKey(%s) = %q, %q, want unlocked", appID, response, key)
+		}
+	}
+	ps, err := loadPairings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.Browsers) != 0 || len(ps.Requests) != 2 {
+		t.Fatalf("Unlocking paired %d browsers and made %d requests, want 0 and 2", len(ps.Browsers), len(ps.Requests))
+	}
+
+	pairTestBrowser(t, store, "app1", "user")
+	wrapped, err := store.GetSecret(copyID("user", "app1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped == "key" {
+		t.Fatal("Browser copy is not wrapped")
+	}
+	path, err := pairingsPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrappingKey, err := store.GetSecret(browserKeyID("app1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bs), wrappingKey) {
+		t.Fatal("Wrapping key is kept in the paired browsers")
+	}
+
+	// Paired browsers are given their copy, not the enrolled key, and only
+	// when started by the executable they were paired with.
+	if err := store.SetSecret("user", "new key"); err != nil {
+		t.Fatal(err)
+	}
+	if response, key := unlock("app1"); response != responseUnlocked || key != "key" {
+		t.Fatalf("unlockKey(app1) = %q, %q, want the copy", response, key)
+	}
+	exe = "/tmp/impostor"
+	if response, key := unlock("app1"); response != responseNotEnabled || key != "" {
+		t.Fatalf("unlockKey(app1) = %q, %q from another executable, want not enabled", response, key)
+	}
+	exe = "/usr/bin/firefox"
+
+	// Once a browser is paired, unknown app ids are refused.
+	for _, appID := range []string{"app2", "app3"} {
+		if response, key := unlock(appID); response != responseNotEnabled || key != "" {
+			t.Fatalf("unlockKey(%s) = %q, %q, want not enabled", appID, response, key)
+		}
+	}
+	pairTestBrowser(t, store, "app2", "user")
+	if response, key := unlock("app2"); response != responseUnlocked || key != "new key" {
+		t.Fatalf("unlockKey(app2) = %q, %q, want the copy", response, key)
+	}
+
+	// Revoking one browser doesn't affect the other.
+	ps, err = loadPairings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedAt := time.Now()
+	ps.get("app1").RevokedAt = &revokedAt
+	if err := ps.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteSecret(browserKeyID("app1")); err != nil {
+		t.Fatal(err)
+	}
+	if response, _ := unlock("app1"); response != responseNotEnabled {
+		t.Fatalf("unlockKey(app1) = %q after revoking, want not enabled", response)
+	}
+	if response, key := unlock("app2"); response != responseUnlocked || key != "new key" {
+		t.Fatalf("unlockKey(app2) = %q, %q, want the copy", response, key)
+	}
+	if _, err := browserCopy(store, "user", ps.get("app1")); !errors.Is(err, secret.ErrNotFound) {
+		t.Fatalf("Revoked copy can still be unwrapped: %v", err)
+	}
+	if _, err := ps.pair(pairingRequest{AppID: "app1", Executable: exe}, time.Now()); err == nil {
+		t.Fatal("Revoked browser was paired again")
+	}
+
+	// Once all paired browsers are revoked, the enrolled key is given out
+	// again.
+	ps.get("app2").RevokedAt = &revokedAt
+	if err := ps.save(); err != nil {
+		t.Fatal(err)
+	}
+	if response, key := unlock("app3"); response != responseUnlocked || key != "new key" {
+		t.Fatalf("unlockKey(app3) = %q, %q with all browsers revoked, want the enrolled key", response, key)
+	}
+}
+
+func TestBrowserForExecutable(t *testing.T) {
+	tests := map[string]string{
+		"firefox":        "firefox",
+		"firefox-bin":    "firefox",
+		"chrome":         "chrome",
+		"chromium":       "chromium",
+		"msedge":         "edge",
+		"vivaldi-bin":    "vivaldi",
+		"brave-browser":  "brave",
+		"something-else": "something-else",
+	}
+	for exe, want := range tests {
+		if got := browserForExecutable(exe); got != want {
+			t.Errorf("browserForExecutable(%q) = %q, want %q", exe, got, want)
+		}
+	}
+}
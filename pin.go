@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/quexten/bw-bio-handler/internal/lockedfile"
+	"github.com/quexten/bw-bio-handler/logging"
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+const defaultPINAttempts = 5
+
+// pinFlags select storing the key wrapped with a PIN.
+type pinFlags struct {
+	enabled bool
+	// disabled allows replacing a key protected by a PIN with one without.
+	disabled bool
+	attempts int
+}
+
+func (f *pinFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.enabled, "pin", false, "protect the stored key with a PIN, which is asked for instead of the system authentication (env BW_BIO_PIN)")
+	fs.BoolVar(&f.disabled, "no-pin", false, "store the key without a PIN, also if the stored key is protected by one")
+	fs.IntVar(&f.attempts, "pin-attempts", defaultPINAttempts, "wipe the stored key after this many wrong PINs")
+}
+
+// pinOptions are the resolved pinFlags.
+type pinOptions struct {
+	pin      string
+	attempts int
+}
+
+// resolve returns nil if no PIN is to be used. The PIN is taken from
+// $BW_BIO_PIN, or prompted for twice.
+func (f *pinFlags) resolve() (*pinOptions, error) {
+	if f.enabled && f.disabled {
+		return nil, errors.New("--pin and --no-pin can't be combined")
+	}
+	if !f.enabled {
+		return nil, nil
+	}
+	if f.attempts < 1 {
+		return nil, fmt.Errorf("--pin-attempts must be at least 1")
+	}
+	if pin := os.Getenv("BW_BIO_PIN"); pin != "" {
+		return &pinOptions{pin: pin, attempts: f.attempts}, nil
+	}
+	pin, err := promptPassword("PIN")
+	if err != nil {
+		return nil, fmt.Errorf("no PIN given: %v", err)
+	}
+	if pin == "" {
+		return nil, errors.New("the PIN must not be empty")
+	}
+	repeated, err := promptPassword("Repeat PIN")
+	if err != nil {
+		return nil, fmt.Errorf("no PIN given: %v", err)
+	}
+	if pin != repeated {
+		return nil, errors.New("the PINs do not match")
+	}
+	return &pinOptions{pin: pin, attempts: f.attempts}, nil
+}
+
+// pinFailuresPath is the file counting the wrong PINs per user. Deleting it
+// resets the counters, but whoever can do that can also read the wrapped key
+// and guess the PIN offline, so the limit is only a guard against guessing
+// through the prompt.
+func pinFailuresPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pin-failures.json"), nil
+}
+
+func loadPINFailures() (map[string]int, error) {
+	path, err := pinFailuresPath()
+	if err != nil {
+		return nil, err
+	}
+	failures := make(map[string]int)
+	bs, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return failures, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &failures); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	return failures, nil
+}
+
+func savePINFailures(failures map[string]int) error {
+	path, err := pinFailuresPath()
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(failures)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bs)
+}
+
+// updatePINFailures applies update to the number of wrong PINs entered for
+// the user, which is reloaded under a lock as other handlers may have
+// changed it. It returns the new number.
+func updatePINFailures(userID string, update func(n int) int) (int, error) {
+	path, err := pinFailuresPath()
+	if err != nil {
+		return 0, err
+	}
+	unlock, err := lockedfile.Lock(path)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	failures, err := loadPINFailures()
+	if err != nil {
+		return 0, err
+	}
+	old, n := failures[userID], update(failures[userID])
+	if n == old {
+		return n, nil
+	}
+	if n == 0 {
+		delete(failures, userID)
+	} else {
+		failures[userID] = n
+	}
+	return n, savePINFailures(failures)
+}
+
+// setPINFailures stores the number of wrong PINs entered for the user.
+func setPINFailures(userID string, n int) error {
+	_, err := updatePINFailures(userID, func(int) int { return n })
+	return err
+}
+
+// unlockWithPIN asks for the PIN of a wrapped key until it is right, the
+// prompt is canceled or the attempts are used up, in which case the key and
+// its browser copies are wiped. It returns the response for the extension
+// and the key, if any.
+func unlockWithPIN(store secret.SecretStore, userID string, value string, ask func(description string, errorMsg string) (string, error)) (string, string) {
+	w, err := secret.ParsePINWrapped(value)
+	if err != nil {
+		logging.Errorf("Could not read the key of user %s: %v", userID, err)
+		return responseNotSupported, ""
+	}
+	failures, err := loadPINFailures()
+	if err != nil {
+		logging.Errorf("Could not read the PIN failures: %v", err)
+		return responseNotSupported, ""
+	}
+
+	errorMsg := ""
+	for n := failures[userID]; n < w.MaxAttempts; {
+		pin, err := ask("Enter the PIN to unlock the Bitwarden browser extension.", errorMsg)
+		if errors.Is(err, errPINCanceled) {
+			return responseCanceled, ""
+		} else if err != nil {
+			logging.Errorf("Could not ask for the PIN: %v", err)
+			return responseNotSupported, ""
+		}
+
+		key, err := w.Unwrap(userID, pin)
+		if err == nil {
+			if err := setPINFailures(userID, 0); err != nil {
+				logging.Errorf("Could not reset the PIN failures: %v", err)
+			}
+			return responseUnlocked, key
+		}
+
+		// Other handlers may have counted wrong PINs in the meantime.
+		n, err = updatePINFailures(userID, func(n int) int { return n + 1 })
+		if err != nil {
+			// Without a counter the attempts can't be limited.
+			logging.Errorf("Could not store the PIN failures: %v", err)
+			return responseNotSupported, ""
+		}
+		if left := w.MaxAttempts - n; left == 1 {
+			errorMsg = "Wrong PIN, 1 attempt left"
+		} else {
+			errorMsg = fmt.Sprintf("Wrong PIN, %d attempts left", left)
+		}
+	}
+
+	logging.Errorf("Too many wrong PINs, wiping the key of user %s", userID)
+	ids := []string{userID}
+	if ps, err := loadPairings(); err == nil {
+		ids = append(ids, ps.copyIDs(userID)...)
+	} else {
+		logging.Errorf("Could not read the paired browsers: %v", err)
+	}
+	for _, id := range ids {
+		if err := store.DeleteSecret(id); err != nil {
+			logging.Errorf("Could not wipe the key of user %s: %v", userID, err)
+			return responseNotSupported, ""
+		}
+	}
+	if err := setPINFailures(userID, 0); err != nil {
+		logging.Errorf("Could not reset the PIN failures: %v", err)
+	}
+	return responseNotEnabled, ""
+}
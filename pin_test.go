@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/quexten/bw-bio-handler/biometrics"
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+// pinStore returns a store holding the key of "user" wrapped with the PIN
+// 1234, and keeps the PIN failures in a temporary directory.
+func pinStore(t *testing.T, attempts int) secret.SecretStore {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	wrapped, err := secret.WrapWithPIN("user", "key", "1234", attempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := secret.NewMemorySecretStore()
+	if err := store.SetSecret("user", wrapped); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// answers returns a PIN prompt giving the PINs in order, and canceling
+// afterwards.
+func answers(pins ...string) func(string, string) (string, error) {
+	return func(string, string) (string, error) {
+		if len(pins) == 0 {
+			return "", errPINCanceled
+		}
+		pin := pins[0]
+		pins = pins[1:]
+		return pin, nil
+	}
+}
+
+func TestUnlockWithPIN(t *testing.T) {
+	store := pinStore(t, 3)
+	auth := biometrics.AuthenticatorFunc(func(context.Context, biometrics.Request) (biometrics.Result, error) {
+		t.Fatal("PIN wrapped key authorized with biometrics")
+		return biometrics.Denied, nil
+	})
+
+	response, key := unlockKey(store, "user", "app", auth, answers("0000", "1234"))
+	if response != responseUnlocked || key != "key" {
+		t.Fatalf("unlockKey() = %q, %q, want unlocked", response, key)
+	}
+	failures, err := loadPINFailures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures["user"] != 0 {
+		t.Fatalf("PIN failures not reset after unlocking: %d", failures["user"])
+	}
+}
+
+func TestUnlockWithPINCanceled(t *testing.T) {
+	store := pinStore(t, 3)
+	response, _ := unlockKey(store, "user", "app", nil, answers("0000"))
+	if response != responseCanceled {
+		t.Fatalf("unlockKey() = %q, want canceled", response)
+	}
+	failures, err := loadPINFailures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures["user"] != 1 {
+		t.Fatalf("PIN failures = %d, want 1", failures["user"])
+	}
+}
+
+func TestUnlockWithPINWipes(t *testing.T) {
+	store := pinStore(t, 3)
+	defer func(enabled bool) { unlockLimitsEnabled = enabled }(unlockLimitsEnabled)
+	unlockLimitsEnabled = false
+	// The failures are counted across unlocks.
+	unlockKey(store, "user", "app", nil, answers("0000"))
+	response, _ := unlockKey(store, "user", "app", nil, answers("1111", "2222", "1234"))
+	if response != responseNotEnabled {
+		t.Fatalf("unlockKey() = %q, want not enabled", response)
+	}
+	if _, err := store.GetSecret("user"); !errors.Is(err, secret.ErrNotFound) {
+		t.Fatalf("Key not wiped after too many wrong PINs: %v", err)
+	}
+}
+
+func TestAskPIN(t *testing.T) {
+	// A pinentry answering GETPIN with a PIN containing an escaped "%".
+	script := `#!/bin/sh
+echo "OK Pleased to meet you"
+while read -r cmd rest; do
+	case "$cmd" in
+	GETPIN) echo "D 12%2534"; echo OK ;;
+	BYE) echo "OK closing connection"; exit 0 ;;
+	*) echo OK ;;
+	esac
+done
+`
+	path := filepath.Join(t.TempDir(), "pinentry")
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BW_BIO_PINENTRY", path)
+
+	pin, err := askPIN("description\nwith a newline", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin != "12%34" {
+		t.Fatalf("askPIN() = %q, want 12%%34", pin)
+	}
+}
+
+func TestPINFlags(t *testing.T) {
+	t.Setenv("BW_BIO_PIN", "1234")
+	tests := []struct {
+		flags pinFlags
+		pin   bool
+		err   bool
+	}{
+		{pinFlags{attempts: 3}, false, false},
+		{pinFlags{disabled: true, attempts: 3}, false, false},
+		{pinFlags{enabled: true, attempts: 3}, true, false},
+		{pinFlags{enabled: true, disabled: true, attempts: 3}, false, true},
+		{pinFlags{enabled: true}, false, true},
+	}
+	for _, test := range tests {
+		pin, err := test.flags.resolve()
+		if (err != nil) != test.err || (pin != nil) != test.pin {
+			t.Errorf("%+v: resolve() = %+v, %v, want a PIN: %v, an error: %v", test.flags, pin, err, test.pin, test.err)
+		}
+	}
+}
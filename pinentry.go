@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// errPINCanceled is returned when the user closes the PIN prompt.
+var errPINCanceled = errors.New("PIN entry canceled")
+
+// pinentryPath returns the pinentry program, which can be overridden with
+// $BW_BIO_PINENTRY.
+func pinentryPath() string {
+	return firstNonEmpty(os.Getenv("BW_BIO_PINENTRY"), "pinentry")
+}
+
+// assuanEscape percent-encodes the characters that can't appear in Assuan
+// command arguments.
+func assuanEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// askPIN asks for a PIN with pinentry. errorMsg is shown above the prompt
+// when set, f.e. after a wrong PIN.
+func askPIN(description string, errorMsg string) (string, error) {
+	return askPinentry(description, "PIN:", errorMsg)
+}
+
+// askPinentry asks for a secret with pinentry, labeling the input with
+// prompt.
+func askPinentry(description string, prompt string, errorMsg string) (string, error) {
+	cmd := exec.Command(pinentryPath())
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("could not start pinentry: %v", err)
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	r := bufio.NewReader(stdout)
+	// The greeting.
+	if _, err := readAssuanResponse(r); err != nil {
+		return "", err
+	}
+	commands := []string{
+		"SETTITLE Bitwarden",
+		"SETDESC " + assuanEscape(description),
+		"SETPROMPT " + assuanEscape(prompt),
+	}
+	if errorMsg != "" {
+		commands = append(commands, "SETERROR "+assuanEscape(errorMsg))
+	}
+	for _, c := range commands {
+		if _, err := fmt.Fprintln(stdin, c); err != nil {
+			return "", err
+		}
+		if _, err := readAssuanResponse(r); err != nil {
+			return "", err
+		}
+	}
+	if _, err := fmt.Fprintln(stdin, "GETPIN"); err != nil {
+		return "", err
+	}
+	pin, err := readAssuanResponse(r)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintln(stdin, "BYE")
+	return pin, nil
+}
+
+// readAssuanResponse reads the lines of a response up to OK or ERR, and
+// returns the decoded data lines.
+func readAssuanResponse(r *bufio.Reader) (string, error) {
+	var data strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return "", errors.New("pinentry exited unexpectedly")
+		} else if err != nil && err != io.EOF {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data.String(), nil
+		case strings.HasPrefix(line, "D "):
+			decoded, err := url.PathUnescape(line[2:])
+			if err != nil {
+				return "", fmt.Errorf("invalid pinentry data: %v", err)
+			}
+			data.WriteString(decoded)
+		case strings.HasPrefix(line, "ERR "):
+			// 99 is GPG_ERR_CANCELED, 83886179 the same with the pinentry
+			// error source.
+			fields := strings.Fields(line)
+			if len(fields) > 1 && (fields[1] == "83886179" || fields[1] == "99") {
+				return "", errPINCanceled
+			}
+			return "", fmt.Errorf("pinentry: %s", strings.TrimPrefix(line, "ERR "))
+		}
+		// Status (S) and comment (#) lines are ignored.
+	}
+}
@@ -154,13 +154,15 @@ func login(ctx context.Context, retryWithApiKey bool) error {
 		if err := jsonPOST(ctx, idtURL+"/connect/token", &tokLogin, values); err != nil {
 			return fmt.Errorf("could not login via two-factor: %v", err)
 		}
-	} else if err != nil && strings.Contains(err.Error(), "Captcha required.") {
-		fmt.Println("The server presented us with a captcha.")
-		fmt.Println("The best way to prevent future captcha is by login at least one time via api-key.")
-		fmt.Println("You can read on how to obtain the keys at: https://bitwarden.com/help/personal-api-key/")
+	} else if err != nil && !retryWithApiKey && strings.Contains(err.Error(), "Captcha required.") {
+		fmt.Fprintln(os.Stderr, "The server presented us with a captcha.")
+		fmt.Fprintln(os.Stderr, "The best way to prevent future captcha is by login at least one time via api-key.")
+		fmt.Fprintln(os.Stderr, "You can read on how to obtain the keys at: https://bitwarden.com/help/personal-api-key/")
 		return login(ctx, true)
+	} else if err != nil && retryWithApiKey {
+		return fmt.Errorf("could not login via API key (client_credentials grant): %v", err)
 	} else if err != nil {
-		return fmt.Errorf("could not login via password: %v", err)
+		return fmt.Errorf("could not login via password (password grant): %v", err)
 	}
 	globalData.AccessToken = tokLogin.AccessToken
 	globalData.RefreshToken = tokLogin.RefreshToken
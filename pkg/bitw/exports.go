@@ -6,39 +6,57 @@ package bitw
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 
 	"github.com/google/uuid"
 )
 
 func DoLogin(email string, password string, urlApi string, urlIdentity string) error {
+	return doLogin(email, password, "", "", urlApi, urlIdentity)
+}
+
+// DoLoginWithAPIKey obtains the access token with a personal API key, which
+// avoids the captcha the server presents to password logins on new devices.
+// The password is still needed to derive the encryption key.
+func DoLoginWithAPIKey(email string, password string, clientID string, clientSecret string, urlApi string, urlIdentity string) error {
+	return doLogin(email, password, clientID, clientSecret, urlApi, urlIdentity)
+}
+
+func doLogin(email string, password string, clientID string, clientSecret string, urlApi string, urlIdentity string) error {
 	if globalData.DeviceID == "" {
 		globalData.DeviceID = uuid.New().String()
 	}
 
 	secrets._password = []byte(password)
 	secrets._configEmail = email
+	if clientID != "" {
+		secrets._clientId = []byte(clientID)
+		secrets._clientSecret = []byte(clientSecret)
+	}
 	apiURL = urlApi
 	idtURL = urlIdentity
 	defer func() {
 		secrets._password = nil
 		secrets._configEmail = ""
+		secrets._clientId = nil
+		secrets._clientSecret = nil
 		apiURL = "https://api.bitwarden.com"
 		idtURL = "https://identity.bitwarden.com"
 	}()
 
 	ctx := context.Background()
-	err := login(ctx, false)
+	// The token obtained via API key has no refresh token, so don't go
+	// through ensureToken, which would log in a second time.
+	err := login(ctx, clientID != "")
 	if err != nil {
 		return err
 	}
-
-	ensureToken(ctx)
 	ctx = context.WithValue(ctx, authToken{}, globalData.AccessToken)
 
-	runSync(ctx)
-	secrets.initKeys()
-
-	return nil
+	if err := runSync(ctx); err != nil {
+		return err
+	}
+	return secrets.initKeys()
 }
 
 func GetEncKeyB64() string {
@@ -49,3 +67,35 @@ func GetEncKeyB64() string {
 func GetUserID() string {
 	return globalData.Sync.Profile.ID.String()
 }
+
+// GetKDF returns the name of the KDF the master key was derived with.
+func GetKDF() string {
+	switch globalData.KDF {
+	case KDFTypePBKDF2:
+		return "pbkdf2"
+	case KDFTypeArgon2id:
+		return "argon2id"
+	}
+	return fmt.Sprintf("unknown (%d)", globalData.KDF)
+}
+
+// VerifyEncKeyB64 checks that the given key decrypts the encryption key of
+// the synced profile.
+func VerifyEncKeyB64(keyB64 string) error {
+	masterKey, err := base64.StdEncoding.DecodeString(keyB64)
+	if err != nil {
+		return err
+	}
+
+	keyCipher := globalData.Sync.Profile.Key
+	switch keyCipher.Type {
+	case AesCbc256_B64:
+		_, err = decryptWith(keyCipher, masterKey, nil)
+	case AesCbc256_HmacSha256_B64:
+		key, macKey := stretchKey(masterKey)
+		_, err = decryptWith(keyCipher, key, macKey)
+	default:
+		err = fmt.Errorf("unsupported key cipher type %q", keyCipher.Type)
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var errNotTerminal = errors.New("stdin is not a terminal")
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// promptLine asks for a single line of input on stderr. It fails if stdin is
+// not a terminal, so that scripts get an error instead of hanging.
+func promptLine(prompt string) (string, error) {
+	if !stdinIsTerminal() {
+		return "", errNotTerminal
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// promptPassword asks for a password without echoing it.
+func promptPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errNotTerminal
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(password) == 0 {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(password), nil
+}
+
+// readPasswordFrom reads the first line of r, which is how passwords are
+// passed via --password-file and --password-fd.
+func readPasswordFrom(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("empty password")
+	}
+	return line, nil
+}
+
+func readPasswordFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readPasswordFrom(f)
+}
+
+func readPasswordFD(fd int) (string, error) {
+	f := os.NewFile(uintptr(fd), "password-fd")
+	if f == nil {
+		return "", fmt.Errorf("invalid password file descriptor %d", fd)
+	}
+	defer f.Close()
+	return readPasswordFrom(f)
+}
@@ -2,14 +2,46 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"os"
+	"time"
 
 	"github.com/quexten/bw-bio-handler/biometrics"
 	"github.com/quexten/bw-bio-handler/logging"
+	"github.com/quexten/bw-bio-handler/secret"
 )
 
+// Responses to biometricUnlock. The browser extension understands the first
+// four, and treats the others as a failed unlock.
+const (
+	responseUnlocked     = "unlocked"
+	responseNotEnabled   = "not enabled"
+	responseNotSupported = "not supported"
+	responseCanceled     = "canceled"
+	// responseLocked tells that the keyring stayed locked, f.e. because its
+	// unlock prompt was dismissed or timed out.
+	responseLocked = "keyring locked"
+	// responseExpired tells that the stored key is past its maximum age and
+	// has to be enrolled again.
+	responseExpired = "key expired"
+	// responseDenied tells that the user failed to authenticate.
+	responseDenied = "denied"
+	// responseTimedOut tells that the user didn't authenticate in time.
+	responseTimedOut = "timed out"
+	// responseRateLimited tells that the caller asked too often, or too soon
+	// after a failed authentication.
+	responseRateLimited = "rate limited"
+	// responseLockedOut tells that the user failed to authenticate too often
+	// and has to run unlock-reset or enroll again.
+	responseLockedOut = "locked out"
+)
+
+// authTimeout is how long the user has to authenticate.
+const authTimeout = time.Minute
+
 func readLoop() {
 	v := bufio.NewReader(os.Stdin)
 	s := bufio.NewReaderSize(v, bufferSize)
@@ -97,35 +129,153 @@ func handlePayloadMessage(msg PayloadMessage, appID string) {
 	switch msg.Command {
 	case "biometricUnlock":
 		logging.Debugf("Biometric unlock requested")
-		isAuthorized := biometrics.CheckBiometrics()
-		logging.Debugf("Biometrics authorized: %t", isAuthorized)
-
-		if isAuthorized {
-			key, err := secretStore.GetSecret(msg.UserId)
-			if err != nil {
-				logging.Panicf(err.Error())
-			}
-
-			var payloadMsg ReceiveMessage = ReceiveMessage{
-				Command:   "biometricUnlock",
-				Response:  "unlocked",
-				Timestamp: msg.Timestamp,
-				KeyB64:    key,
-			}
-			payloadStr, err := json.Marshal(payloadMsg)
-			if err != nil {
-				logging.Panicf(err.Error())
-			}
-			logging.Debugf("Payload: %s", payloadStr)
-
-			encStr := encryptStringSymmetric(transportKey, payloadStr)
-			send(SendMessage{
-				AppID:   appID,
-				Message: encStr,
-			})
-		} else {
-			logging.Panicf("Biometrics not authorized")
-		}
+		response, key := unlockKey(secretStore, msg.UserId, appID, authenticator, askPIN)
+		sendBiometricResponse(appID, msg.Timestamp, response, key)
 		break
 	}
 }
+
+// unlockKey reads the copy of the key of the user for the browser with the
+// app id and authorizes its release, with the PIN if the key is wrapped with
+// one and with auth otherwise, unless the user authenticated within the
+// grace period. Callers causing too many authentication or PIN prompts are
+// refused, see unlockLimits. Until a browser is paired, all of them are
+// given the enrolled key. Afterwards, browsers that aren't paired with their
+// app id and executable make a pairing request and are refused, as are
+// revoked browsers and expired keys. It returns the response for the
+// extension and the key, if any.
+func unlockKey(store secret.SecretStore, userID string, appID string, auth biometrics.Authenticator, ask func(description string, errorMsg string) (string, error)) (string, string) {
+	ps, err := loadPairings()
+	if err != nil {
+		logging.Errorf("Could not read the paired browsers: %v", err)
+		return responseNotSupported, ""
+	}
+	b := ps.get(appID)
+	exe := parentExecutable()
+	var stored string
+	switch {
+	case b != nil && b.revoked():
+		logging.Errorf("Refusing to unlock, %s with app id %s has been revoked", b.Browser, appID)
+		return responseNotEnabled, ""
+	case b != nil && b.Executable != exe:
+		logging.Errorf("Refusing to unlock, %s with app id %s is paired with another executable than %s", b.Browser, appID, exe)
+		requestPairing(appID, exe)
+		return responseNotEnabled, ""
+	case b != nil:
+		stored, err = browserCopy(store, userID, b)
+	case len(ps.active()) > 0:
+		logging.Errorf("Refusing to unlock, app id %s is not paired", appID)
+		requestPairing(appID, exe)
+		return responseNotEnabled, ""
+	default:
+		requestPairing(appID, exe)
+		stored, err = store.GetSecret(userID)
+	}
+	if err != nil {
+		logging.Errorf("Could not get the key of user %s: %v", userID, err)
+		return unlockErrorResponse(err), ""
+	}
+	value, expiresAt, err := secret.SplitExpiry(stored)
+	if err != nil {
+		logging.Errorf("Could not read the key of user %s: %v", userID, err)
+		return responseNotSupported, ""
+	}
+	if !expiresAt.IsZero() && !time.Now().Before(expiresAt) {
+		logging.Errorf("Refusing to unlock, the key of user %s expired at %s", userID, expiresAt)
+		notifyExpired(store, userID)
+		return responseExpired, ""
+	}
+
+	pinWrapped := secret.IsPINWrapped(value)
+	if !pinWrapped && inGracePeriod(userID) {
+		logging.Debugf("Unlocking without authentication in the grace period of user %s", userID)
+		return responseUnlocked, value
+	}
+	if response := checkUnlockLimits(userID, exe); response != "" {
+		return response, ""
+	}
+	var response, key string
+	if pinWrapped {
+		response, key = unlockWithPIN(store, userID, value, ask)
+	} else {
+		response, key = authenticate(store, userID, b, auth, value)
+	}
+	recordUnlockResult(store, userID, exe, response)
+	if response == responseUnlocked && !pinWrapped {
+		startGracePeriod(userID)
+	}
+	return response, key
+}
+
+// authenticate asks auth to authorize releasing the key of the user to the
+// browser b, which is nil if it isn't paired yet. It returns the response
+// for the extension and the key, if granted.
+func authenticate(store secret.SecretStore, userID string, b *pairedBrowser, auth biometrics.Authenticator, value string) (string, string) {
+	browser := detectBrowser()
+	if b != nil {
+		browser = b.Browser
+	}
+	req := biometrics.Request{Email: accountEmail(store, userID), Browser: browser, Action: "unlock"}
+	ctx, cancel := context.WithTimeout(context.Background(), authTimeout)
+	result, err := auth.Authenticate(ctx, req)
+	cancel()
+	logging.Debugf("Authentication %s", result)
+	if err != nil {
+		logging.Errorf("Authentication %s: %v", result, err)
+	}
+	response := authResponse(result)
+	if response != responseUnlocked {
+		return response, ""
+	}
+	return response, value
+}
+
+// authResponse maps the result of authenticating the user to the response
+// for the extension.
+func authResponse(result biometrics.Result) string {
+	switch result {
+	case biometrics.Granted:
+		return responseUnlocked
+	case biometrics.Denied:
+		return responseDenied
+	case biometrics.Canceled:
+		return responseCanceled
+	case biometrics.TimedOut:
+		return responseTimedOut
+	default:
+		return responseNotSupported
+	}
+}
+
+// unlockErrorResponse maps the error of reading a key from the secret store
+// to the response for the extension.
+func unlockErrorResponse(err error) string {
+	switch {
+	case errors.Is(err, secret.ErrNotFound):
+		return responseNotEnabled
+	case errors.Is(err, secret.ErrLocked):
+		return responseLocked
+	default:
+		return responseNotSupported
+	}
+}
+
+func sendBiometricResponse(appID string, timestamp int64, response string, key string) {
+	var payloadMsg ReceiveMessage = ReceiveMessage{
+		Command:   "biometricUnlock",
+		Response:  response,
+		Timestamp: timestamp,
+		KeyB64:    key,
+	}
+	payloadStr, err := json.Marshal(payloadMsg)
+	if err != nil {
+		logging.Panicf(err.Error())
+	}
+	logging.Debugf("Payload: %s", payloadStr)
+
+	encStr := encryptStringSymmetric(transportKey, payloadStr)
+	send(SendMessage{
+		AppID:   appID,
+		Message: encStr,
+	})
+}
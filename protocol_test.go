@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/quexten/bw-bio-handler/biometrics"
+	"github.com/quexten/bw-bio-handler/secret"
+	"github.com/quexten/bw-bio-handler/secret/secrettest"
+)
+
+func TestUnlockKey(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	store := secret.NewMemorySecretStore()
+	if err := store.SetSecret("enrolled", "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		store    secret.SecretStore
+		userID   string
+		response string
+		key      string
+	}{
+		{"enrolled", store, "enrolled", responseUnlocked, "key"},
+		{"not enrolled", store, "other", responseNotEnabled, ""},
+		{"locked", secrettest.ErrorStore{Err: fmt.Errorf("%w: prompt dismissed", secret.ErrLocked)}, "enrolled", responseLocked, ""},
+		{"pin required", secrettest.ErrorStore{Err: secret.ErrPinRequired}, "enrolled", responseLocked, ""},
+		{"unavailable", secrettest.ErrorStore{Err: fmt.Errorf("%w: no D-Bus", secret.ErrUnavailable)}, "enrolled", responseNotSupported, ""},
+		{"other error", secrettest.ErrorStore{Err: errors.New("something else")}, "enrolled", responseNotSupported, ""},
+	}
+	for _, test := range tests {
+		auth := authResult(biometrics.Granted)
+		ask := func(string, string) (string, error) { return "", errPINCanceled }
+		response, key := unlockKey(test.store, test.userID, "app", auth, ask)
+		if response != test.response || key != test.key {
+			t.Errorf("%s: unlockKey() = %q, %q, want %q, %q", test.name, response, key, test.response, test.key)
+		}
+	}
+}
+
+// authResult returns an Authenticator always returning result.
+func authResult(result biometrics.Result) biometrics.Authenticator {
+	return biometrics.AuthenticatorFunc(func(context.Context, biometrics.Request) (biometrics.Result, error) {
+		return result, nil
+	})
+}
+
+func TestUnlockKeyAuthentication(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	defer func(enabled bool) { unlockLimitsEnabled = enabled }(unlockLimitsEnabled)
+	unlockLimitsEnabled = false
+	store := secret.NewMemorySecretStore()
+	if err := store.SetSecret("user", "key"); err != nil {
+		t.Fatal(err)
+	}
+	idx := &accountIndex{Accounts: []account{{UserID: "user", Email: "user@example.com"}}}
+	if err := idx.save(&changeSet{p: newPrinter(true)}, store, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		result   biometrics.Result
+		response string
+		key      string
+	}{
+		{biometrics.Denied, responseDenied, ""},
+		{biometrics.Canceled, responseCanceled, ""},
+		{biometrics.Unavailable, responseNotSupported, ""},
+		{biometrics.TimedOut, responseTimedOut, ""},
+		{biometrics.Granted, responseUnlocked, "key"},
+	}
+	for _, test := range tests {
+		var req biometrics.Request
+		auth := biometrics.AuthenticatorFunc(func(ctx context.Context, r biometrics.Request) (biometrics.Result, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Error("Authenticate called without a deadline")
+			}
+			req = r
+			return test.result, nil
+		})
+		response, key := unlockKey(store, "user", "app", auth, answers())
+		if response != test.response || key != test.key {
+			t.Errorf("%s: unlockKey() = %q, %q, want %q, %q", test.result, response, key, test.response, test.key)
+		}
+		if req.Email != "user@example.com" || req.Action != "unlock" {
+			t.Errorf("%s: Authenticate called with %+v", test.result, req)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+type revokeResult struct {
+	Status   string          `json:"status"`
+	Error    string          `json:"error,omitempty"`
+	DryRun   bool            `json:"dryRun,omitempty"`
+	Browsers []pairedBrowser `json:"browsers,omitempty"`
+	// Requests are the pending pairing requests, listed by --list.
+	Requests []pairingRequest `json:"requests,omitempty"`
+	Changes  []change         `json:"changes,omitempty"`
+}
+
+// runRevoke deletes the copies of the keys of a single paired browser, so
+// that it can't unlock anymore while the other browsers are unaffected.
+func runRevoke(args []string) int {
+	fs := flag.NewFlagSet("revoke", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bw-bio-handler revoke [flags] <browser or app id>")
+		fs.PrintDefaults()
+	}
+	list := fs.Bool("list", false, "list the paired browsers and pairing requests instead")
+	dryRun := fs.Bool("dry-run", false, "only print what would be changed")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *list == (fs.NArg() == 1) || fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+
+	p := newPrinter(*jsonOutput)
+	changes := &changeSet{p: p, dryRun: *dryRun}
+	res := &revokeResult{Status: "ok", DryRun: *dryRun}
+	var err error
+	if *list {
+		err = listPairings(p, res)
+	} else {
+		err = revoke(p, changes, fs.Arg(0), res)
+	}
+	res.Changes = changes.changes
+	if err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+		p.result(res)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	p.result(res)
+	return 0
+}
+
+func listPairings(p *printer, res *revokeResult) error {
+	ps, err := loadPairings()
+	if err != nil {
+		return err
+	}
+	res.Browsers = ps.Browsers
+	res.Requests = ps.Requests
+	if p.json {
+		return nil
+	}
+	if len(ps.Browsers) == 0 && len(ps.Requests) == 0 {
+		fmt.Println("No browsers paired.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "APP ID\tBROWSER\tEXECUTABLE\tPAIRED\tREVOKED")
+	for _, b := range ps.Browsers {
+		revoked := ""
+		if b.revoked() {
+			revoked = b.RevokedAt.Local().Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", b.AppID, b.Browser, b.Executable, b.PairedAt.Local().Format("2006-01-02"), revoked)
+	}
+	for _, r := range ps.Requests {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", r.AppID, r.Browser, r.Executable, "requested "+r.RequestedAt.Local().Format("2006-01-02"))
+	}
+	w.Flush()
+	return nil
+}
+
+// revoke deletes the browser's copies of the keys of all enrolled accounts
+// and the key wrapping them. The browser stays listed as revoked, so that it
+// can't be paired again.
+func revoke(p *printer, changes *changeSet, query string, res *revokeResult) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("could not load config: %v", err)
+	}
+	unlock, err := lockPairings()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ps, err := loadPairings()
+	if err != nil {
+		return err
+	}
+	b, err := ps.find(query)
+	if err != nil {
+		return err
+	}
+	if b.revoked() {
+		return fmt.Errorf("%s with app id %s has already been revoked", b.Browser, b.AppID)
+	}
+	store, _, err := openSecretStore(cfg)
+	if err != nil {
+		return err
+	}
+	idx, err := loadAccountIndex(store)
+	if err != nil {
+		return err
+	}
+
+	p.Printf("Revoking %s with app id %s...\n", b.Browser, b.AppID)
+	for _, a := range idx.Accounts {
+		if err := changes.deleteSecret(store, copyID(a.UserID, b.AppID)); err != nil {
+			return fmt.Errorf("failed to delete the copy of the key of %s: %v", a.Email, err)
+		}
+	}
+	if err := changes.deleteSecret(store, browserKeyID(b.AppID)); err != nil {
+		return fmt.Errorf("failed to delete the key of %s: %v", b.Browser, err)
+	}
+	revokedAt := time.Now().UTC()
+	b.RevokedAt = &revokedAt
+	res.Browsers = []pairedBrowser{*b}
+	if err := changes.savePairings(ps, "revoke "+b.AppID); err != nil {
+		return fmt.Errorf("failed to update the paired browsers: %v", err)
+	}
+
+	if changes.dryRun {
+		p.Println("Dry run, nothing was changed.")
+		return nil
+	}
+	p.Println("Revoked. The other browsers are not affected.")
+	return nil
+}
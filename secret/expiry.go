@@ -0,0 +1,35 @@
+package secret
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expiryPrefix marks stored values that carry an expiry date, as
+// "bw-bio-expires:<unix time>:<value>".
+const expiryPrefix = "bw-bio-expires:"
+
+// WithExpiry marks value to expire at expiresAt. The expiry is not
+// protected, whoever can change it can read the value anyway.
+func WithExpiry(value string, expiresAt time.Time) string {
+	return expiryPrefix + strconv.FormatInt(expiresAt.Unix(), 10) + ":" + value
+}
+
+// SplitExpiry returns the value marked by WithExpiry and its expiry date.
+// Values without expiry are returned as they are, with the zero time.
+func SplitExpiry(value string) (string, time.Time, error) {
+	if !strings.HasPrefix(value, expiryPrefix) {
+		return value, time.Time{}, nil
+	}
+	unix, rest, ok := strings.Cut(strings.TrimPrefix(value, expiryPrefix), ":")
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("invalid expiring secret")
+	}
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid expiry date: %v", err)
+	}
+	return rest, time.Unix(seconds, 0), nil
+}
@@ -0,0 +1,31 @@
+package secret_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+func TestExpiry(t *testing.T) {
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	value, got, err := secret.SplitExpiry(secret.WithExpiry("key:with:colons", expiresAt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "key:with:colons" || !got.Equal(expiresAt) {
+		t.Fatalf("SplitExpiry() = %q, %v, want the value and %v", value, got, expiresAt)
+	}
+
+	value, got, err = secret.SplitExpiry("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "key" || !got.IsZero() {
+		t.Fatalf("SplitExpiry() = %q, %v for a value without expiry", value, got)
+	}
+
+	if _, _, err := secret.SplitExpiry("bw-bio-expires:soon:key"); err == nil {
+		t.Fatal("SplitExpiry accepted an invalid expiry date")
+	}
+}
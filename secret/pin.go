@@ -0,0 +1,102 @@
+package secret
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// pinPrefix marks stored values that are wrapped with a PIN.
+const pinPrefix = "bw-bio-pin:"
+
+const pinFormatVersion = 1
+
+// ErrWrongPIN is returned by PINWrapped.Unwrap for a wrong PIN.
+var ErrWrongPIN = errors.New("wrong PIN")
+
+// PINWrapped is a secret encrypted with AES-256-GCM under a key derived via
+// Argon2id from a PIN. It is stored in place of the plain secret, with the
+// user ID as additional data so that it can't be moved to another user.
+type PINWrapped struct {
+	Version int       `json:"version"`
+	KDF     fileKDF   `json:"kdf"`
+	Entry   fileEntry `json:"entry"`
+	// MaxAttempts is the number of wrong PINs after which the secret is
+	// wiped.
+	MaxAttempts int `json:"maxAttempts"`
+}
+
+// WrapWithPIN encrypts value under pin and returns the value to store.
+func WrapWithPIN(userID string, value string, pin string, maxAttempts int) (string, error) {
+	if pin == "" {
+		return "", errors.New("empty PIN")
+	}
+	if maxAttempts < 1 {
+		return "", fmt.Errorf("invalid number of PIN attempts %d", maxAttempts)
+	}
+	salt := make([]byte, saltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	w := &PINWrapped{
+		Version: pinFormatVersion,
+		KDF: fileKDF{
+			Type:    "argon2id",
+			Salt:    salt,
+			Time:    argon2Time,
+			Memory:  argon2Memory,
+			Threads: argon2Threads,
+		},
+		MaxAttempts: maxAttempts,
+	}
+	var err error
+	if w.Entry, err = sealEntry(w.deriveKey(pin), value, userID); err != nil {
+		return "", err
+	}
+	bs, err := json.Marshal(w)
+	if err != nil {
+		return "", err
+	}
+	return pinPrefix + string(bs), nil
+}
+
+// IsPINWrapped tells whether a stored value is wrapped with a PIN.
+func IsPINWrapped(value string) bool {
+	return strings.HasPrefix(value, pinPrefix)
+}
+
+// ParsePINWrapped parses a stored value returned by WrapWithPIN.
+func ParsePINWrapped(value string) (*PINWrapped, error) {
+	if !IsPINWrapped(value) {
+		return nil, errors.New("secret is not wrapped with a PIN")
+	}
+	w := &PINWrapped{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(value, pinPrefix)), w); err != nil {
+		return nil, fmt.Errorf("invalid PIN wrapped secret: %v", err)
+	}
+	if w.Version != pinFormatVersion {
+		return nil, fmt.Errorf("unsupported PIN wrapped secret version %d", w.Version)
+	}
+	if w.KDF.Type != "argon2id" {
+		return nil, fmt.Errorf("unsupported kdf %q", w.KDF.Type)
+	}
+	return w, nil
+}
+
+func (w *PINWrapped) deriveKey(pin string) []byte {
+	return argon2.IDKey([]byte(pin), w.KDF.Salt, w.KDF.Time, w.KDF.Memory, w.KDF.Threads, argon2KeyLen)
+}
+
+// Unwrap decrypts the secret of the user, or returns ErrWrongPIN.
+func (w *PINWrapped) Unwrap(userID string, pin string) (string, error) {
+	value, err := openEntry(w.deriveKey(pin), w.Entry, userID)
+	if err != nil {
+		return "", ErrWrongPIN
+	}
+	return value, nil
+}
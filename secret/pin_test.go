@@ -0,0 +1,39 @@
+package secret_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+func TestPINWrapping(t *testing.T) {
+	wrapped, err := secret.WrapWithPIN("user", "key", "1234", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secret.IsPINWrapped(wrapped) || secret.IsPINWrapped("key") {
+		t.Fatal("IsPINWrapped does not tell wrapped and plain secrets apart")
+	}
+
+	w, err := secret.ParsePINWrapped(wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.MaxAttempts != 3 {
+		t.Fatalf("MaxAttempts = %d, want 3", w.MaxAttempts)
+	}
+	if _, err := w.Unwrap("user", "4321"); !errors.Is(err, secret.ErrWrongPIN) {
+		t.Fatalf("Expected ErrWrongPIN for a wrong PIN, got %v", err)
+	}
+	if _, err := w.Unwrap("other", "1234"); !errors.Is(err, secret.ErrWrongPIN) {
+		t.Fatalf("Expected ErrWrongPIN for another user, got %v", err)
+	}
+	key, err := w.Unwrap("user", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "key" {
+		t.Fatalf("Unwrap() = %q, want key", key)
+	}
+}
@@ -0,0 +1,372 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/quexten/bw-bio-handler/internal/lockedfile"
+	"golang.org/x/crypto/argon2"
+)
+
+const fileFormatVersion = 1
+
+// checkValue is encrypted into the file header, to tell a wrong passphrase
+// apart from a corrupted entry.
+const checkValue = "bw-bio-handler"
+
+// Argon2id parameters for new files. They are stored in the file, so that
+// they can be raised later without breaking existing files.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	saltLen       = 16
+)
+
+type fileKDF struct {
+	Type    string `json:"type"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+func (k fileKDF) equal(other fileKDF) bool {
+	return k.Type == other.Type && string(k.Salt) == string(other.Salt) &&
+		k.Time == other.Time && k.Memory == other.Memory && k.Threads == other.Threads
+}
+
+type fileEntry struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type secretFile struct {
+	Version int                  `json:"version"`
+	KDF     fileKDF              `json:"kdf"`
+	Check   fileEntry            `json:"check"`
+	Entries map[string]fileEntry `json:"entries"`
+}
+
+// FileSecretStore keeps the secrets in a single file. Every entry is
+// encrypted with AES-256-GCM under a key-encryption key derived via Argon2id
+// from a passphrase or the contents of a key file, with the user ID as
+// additional data so that entries can't be swapped. Writes lock the file,
+// as every browser starts its own handler.
+type FileSecretStore struct {
+	mu         sync.Mutex
+	path       string
+	passphrase []byte
+
+	// kek is derived lazily from kdf, as Argon2id is expensive. Until the
+	// file is created, kdf is the one it will be created with.
+	kek []byte
+	kdf fileKDF
+}
+
+// DefaultFilePath returns the location of the secret file in DataDir.
+func DefaultFilePath() (string, error) {
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "secrets.json"), nil
+}
+
+// NewFileSecretStore opens the secret file at path, which is created on the
+// first write. An existing file is checked against the passphrase.
+func NewFileSecretStore(path string, passphrase []byte) (*FileSecretStore, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase for file secret store")
+	}
+	s := &FileSecretStore{path: path, passphrase: passphrase}
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewFileSecretStoreFromKeyFile is like NewFileSecretStore, with the
+// contents of keyFile as the passphrase.
+func NewFileSecretStoreFromKeyFile(path string, keyFile string) (*FileSecretStore, error) {
+	if err := checkPermissions(keyFile); err != nil {
+		return nil, err
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileSecretStore(path, key)
+}
+
+func init() {
+	register("file", 20, openFileStore)
+}
+
+// openFileStore opens the file secret store at opts.FilePath with the key
+// file or passphrase of opts.
+func openFileStore(explicit bool, opts Options) (SecretStore, error) {
+	keyFile := opts.FileKeyFile
+	passphrase := opts.FilePassphrase
+	if keyFile == "" && passphrase == "" {
+		return nil, errors.New("not configured, set a key file or passphrase")
+	}
+	path := opts.FilePath
+	if path == "" {
+		var err error
+		if path, err = DefaultFilePath(); err != nil {
+			return nil, err
+		}
+	}
+	if keyFile != "" {
+		return NewFileSecretStoreFromKeyFile(path, keyFile)
+	}
+	return NewFileSecretStore(path, []byte(passphrase))
+}
+
+// checkPermissions refuses files that are accessible by other users.
+func checkPermissions(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s is accessible by other users (mode %o), it must not be", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+func (s *FileSecretStore) deriveKEK(kdf fileKDF) ([]byte, error) {
+	if kdf.Type != "argon2id" {
+		return nil, fmt.Errorf("unsupported kdf %q", kdf.Type)
+	}
+	if s.kek != nil && s.kdf.equal(kdf) {
+		return s.kek, nil
+	}
+	s.kek = argon2.IDKey(s.passphrase, kdf.Salt, kdf.Time, kdf.Memory, kdf.Threads, argon2KeyLen)
+	s.kdf = kdf
+	return s.kek, nil
+}
+
+// load reads the secret file, or initializes a new one if there is none.
+func (s *FileSecretStore) load() (*secretFile, error) {
+	bs, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s.newFile()
+	} else if err != nil {
+		return nil, err
+	}
+	if err := checkPermissions(s.path); err != nil {
+		return nil, err
+	}
+
+	f := &secretFile{}
+	if err := json.Unmarshal(bs, f); err != nil {
+		return nil, fmt.Errorf("invalid secret file %s: %v", s.path, err)
+	}
+	if f.Version != fileFormatVersion {
+		return nil, fmt.Errorf("unsupported secret file version %d", f.Version)
+	}
+	if f.Entries == nil {
+		f.Entries = make(map[string]fileEntry)
+	}
+	kek, err := s.deriveKEK(f.KDF)
+	if err != nil {
+		return nil, err
+	}
+	check, err := openEntry(kek, f.Check, "")
+	if err != nil || check != checkValue {
+		return nil, fmt.Errorf("%w: wrong passphrase for file secret store", ErrLocked)
+	}
+	return f, nil
+}
+
+// newFile initializes a new secret file. The salt of the previous call is
+// reused until the file is written, so that the key isn't derived again on
+// every read of a store without a file.
+func (s *FileSecretStore) newFile() (*secretFile, error) {
+	kdf := s.kdf
+	if s.kek == nil {
+		salt := make([]byte, saltLen)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, err
+		}
+		kdf = fileKDF{
+			Type:    "argon2id",
+			Salt:    salt,
+			Time:    argon2Time,
+			Memory:  argon2Memory,
+			Threads: argon2Threads,
+		}
+	}
+	f := &secretFile{
+		Version: fileFormatVersion,
+		KDF:     kdf,
+		Entries: make(map[string]fileEntry),
+	}
+	kek, err := s.deriveKEK(f.KDF)
+	if err != nil {
+		return nil, err
+	}
+	if f.Check, err = sealEntry(kek, checkValue, ""); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// save atomically replaces the secret file: the new content is written to a
+// temporary file in the same directory, synced and renamed over the old one.
+func (s *FileSecretStore) save(f *secretFile) error {
+	bs, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".secrets-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// lock serializes a read-modify-write of the file with the other goroutines
+// and handlers writing it.
+func (s *FileSecretStore) lock() (func(), error) {
+	s.mu.Lock()
+	unlock, err := lockedfile.Lock(s.path)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *FileSecretStore) GetSecret(userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	entry, ok := f.Entries[userID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return openEntry(s.kek, entry, userID)
+}
+
+func (s *FileSecretStore) SetSecret(userID string, value string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Re-read the file under the lock, so that writes of other processes
+	// since opening the store are not lost.
+	f, err := s.load()
+	if err != nil {
+		return err
+	}
+	entry, err := sealEntry(s.kek, value, userID)
+	if err != nil {
+		return err
+	}
+	f.Entries[userID] = entry
+	return s.save(f)
+}
+
+func (s *FileSecretStore) DeleteSecret(userID string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Entries[userID]; !ok {
+		return nil
+	}
+	delete(f.Entries, userID)
+	return s.save(f)
+}
+
+func sealEntry(kek []byte, value string, userID string) (fileEntry, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return fileEntry{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fileEntry{}, err
+	}
+	return fileEntry{
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, []byte(value), []byte(userID)),
+	}, nil
+}
+
+func openEntry(kek []byte, entry fileEntry, userID string) (string, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return "", err
+	}
+	if len(entry.Nonce) != aead.NonceSize() {
+		return "", errors.New("invalid nonce length")
+	}
+	plaintext, err := aead.Open(nil, entry.Nonce, entry.Ciphertext, []byte(userID))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt secret of %q: %v", userID, err)
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
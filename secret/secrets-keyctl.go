@@ -0,0 +1,147 @@
+//go:build linux
+
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Key permissions, see keyctl(2). x/sys/unix doesn't define them.
+const (
+	keyPosAll     = 0x3f000000
+	keyUsrView    = 0x00010000
+	keyUsrRead    = 0x00020000
+	keyUsrWrite   = 0x00040000
+	keyUsrSearch  = 0x00080000
+	keyUsrSetattr = 0x00200000
+)
+
+// KeyctlSecretStore keeps the secrets in the kernel's user or session
+// keyring. Nothing is persisted, the keys are gone after a reboot (user
+// keyring) or at the end of the login session (session keyring), or when
+// the optional timeout expires.
+type KeyctlSecretStore struct {
+	ringID  int
+	timeout time.Duration
+}
+
+// NewKeyctlSecretStore returns a store backed by the "user" or "session"
+// keyring. A timeout of zero keeps the keys until the keyring goes away.
+func NewKeyctlSecretStore(keyring string, timeout time.Duration) (*KeyctlSecretStore, error) {
+	var ringID int
+	switch keyring {
+	case "", "user":
+		ringID = unix.KEY_SPEC_USER_KEYRING
+	case "session":
+		ringID = unix.KEY_SPEC_SESSION_KEYRING
+	default:
+		return nil, fmt.Errorf("unknown keyring %q, must be user or session", keyring)
+	}
+	// Make sure the keyring exists and keyctl isn't blocked, f.e. by a
+	// seccomp filter.
+	if _, err := unix.KeyctlGetKeyringID(ringID, true); err != nil {
+		return nil, fmt.Errorf("%w: kernel keyring: %v", ErrUnavailable, err)
+	}
+	return &KeyctlSecretStore{ringID: ringID, timeout: timeout}, nil
+}
+
+func init() {
+	register("keyctl", 10, openKeyctlStore)
+}
+
+// openKeyctlStore opens the keyring named by $BW_BIO_KEYCTL_KEYRING, which
+// defaults to "user" when the backend is selected explicitly.
+// $BW_BIO_KEYCTL_TIMEOUT optionally sets the timeout, f.e. "8h".
+func openKeyctlStore(explicit bool, opts Options) (SecretStore, error) {
+	keyring := os.Getenv("BW_BIO_KEYCTL_KEYRING")
+	if keyring == "" && !explicit {
+		return nil, errors.New("not configured, set BW_BIO_KEYCTL_KEYRING")
+	}
+	var timeout time.Duration
+	if s := os.Getenv("BW_BIO_KEYCTL_TIMEOUT"); s != "" {
+		var err error
+		if timeout, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid BW_BIO_KEYCTL_TIMEOUT: %v", err)
+		}
+	}
+	return NewKeyctlSecretStore(keyring, timeout)
+}
+
+func keyDescription(userID string) string {
+	return service + ":" + userID
+}
+
+// search returns the ID of the user's key, or ErrNotFound if there is none.
+func (s *KeyctlSecretStore) search(userID string) (int, error) {
+	id, err := unix.KeyctlSearch(s.ringID, "user", keyDescription(userID), 0)
+	if errors.Is(err, unix.ENOKEY) || errors.Is(err, unix.EKEYEXPIRED) || errors.Is(err, unix.EKEYREVOKED) {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *KeyctlSecretStore) GetSecret(userID string) (string, error) {
+	id, err := s.search(userID)
+	if err != nil {
+		return "", err
+	}
+	// A nil buffer returns the size of the payload.
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if errors.Is(err, unix.EKEYEXPIRED) || errors.Is(err, unix.EKEYREVOKED) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if n > len(buf) {
+		n = len(buf)
+	}
+	return string(buf[:n]), nil
+}
+
+func (s *KeyctlSecretStore) SetSecret(userID string, value string) error {
+	// add_key updates the payload if the key already exists.
+	id, err := unix.AddKey("user", keyDescription(userID), []byte(value), s.ringID)
+	if err != nil {
+		return err
+	}
+	// Only processes of the owning user may access the key.
+	perm := uint32(keyPosAll | keyUsrView | keyUsrRead | keyUsrWrite | keyUsrSearch | keyUsrSetattr)
+	if err := unix.KeyctlSetperm(id, perm); err != nil {
+		return err
+	}
+	if s.timeout > 0 {
+		seconds := int((s.timeout + time.Second - 1) / time.Second)
+		if _, err := unix.KeyctlInt(unix.KEYCTL_SET_TIMEOUT, id, seconds, 0, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *KeyctlSecretStore) DeleteSecret(userID string) error {
+	id, err := s.search(userID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// Invalidating discards the key right away, instead of waiting for the
+	// garbage collector after unlinking.
+	if _, err := unix.KeyctlInt(unix.KEYCTL_INVALIDATE, id, 0, 0, 0); err == nil {
+		return nil
+	}
+	_, err = unix.KeyctlInt(unix.KEYCTL_UNLINK, id, s.ringID, 0, 0)
+	return err
+}
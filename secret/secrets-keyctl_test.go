@@ -0,0 +1,37 @@
+//go:build linux
+
+package secret_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/quexten/bw-bio-handler/secret"
+	"github.com/quexten/bw-bio-handler/secret/secrettest"
+)
+
+func newKeyctlStore(t *testing.T, timeout time.Duration) *secret.KeyctlSecretStore {
+	store, err := secret.NewKeyctlSecretStore("session", timeout)
+	if err != nil {
+		t.Skip(err)
+	}
+	return store
+}
+
+func TestKeyctlStoreFunctionality(t *testing.T) {
+	secrettest.TestStore(t, newKeyctlStore(t, 0))
+}
+
+func TestKeyctlStoreTimeout(t *testing.T) {
+	store := newKeyctlStore(t, time.Second)
+	if err := store.SetSecret("bw-bio-test-timeout", "test"); err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteSecret("bw-bio-test-timeout")
+
+	time.Sleep(2 * time.Second)
+	if _, err := store.GetSecret("bw-bio-test-timeout"); !errors.Is(err, secret.ErrNotFound) {
+		t.Fatalf("Secret not discarded after timeout: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package secret
+
+import "sync"
+
+func init() {
+	register("memory", -1, func(explicit bool, opts Options) (SecretStore, error) {
+		return NewMemorySecretStore(), nil
+	})
+}
+
+// MemorySecretStore keeps the secrets in memory only. It is lost when the
+// process exits, which makes it useful for tests and dry runs.
+type MemorySecretStore struct {
+	mu       sync.Mutex
+	secrets  map[string]string
+	metadata map[string]Metadata
+}
+
+func NewMemorySecretStore() *MemorySecretStore {
+	return &MemorySecretStore{
+		secrets:  make(map[string]string),
+		metadata: make(map[string]Metadata),
+	}
+}
+
+func (s *MemorySecretStore) GetSecret(userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.secrets[userID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *MemorySecretStore) SetSecret(userID string, value string) error {
+	return s.SetSecretWithMetadata(userID, value, Metadata{})
+}
+
+func (s *MemorySecretStore) SetSecretWithMetadata(userID string, value string, meta Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[userID] = value
+	s.metadata[userID] = meta
+	return nil
+}
+
+func (s *MemorySecretStore) GetMetadata(userID string) (Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[userID]; !ok {
+		return Metadata{}, ErrNotFound
+	}
+	return s.metadata[userID], nil
+}
+
+func (s *MemorySecretStore) DeleteSecret(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.secrets, userID)
+	delete(s.metadata, userID)
+	return nil
+}
@@ -3,40 +3,70 @@
 package secret
 
 import (
-	"errors"
+	"fmt"
 
 	"github.com/keybase/go-keychain"
 )
 
-func GetStore() (SecretStore, error) {
-	return &KeychainSecretStore{}, nil
+func init() {
+	register("keychain", 30, func(explicit bool, opts Options) (SecretStore, error) {
+		return &KeychainSecretStore{}, nil
+	})
 }
 
 type KeychainSecretStore struct {
 }
 
-func (s *KeychainSecretStore) GetSecret(key string) (string, error) {
-	return "", errors.New("Not implemented on OSX")
+// keychainError maps the keychain result codes to ErrLocked and
+// ErrUnavailable.
+func keychainError(err error) error {
+	switch err {
+	case keychain.ErrorInteractionNotAllowed, keychain.ErrorAuthFailed:
+		return fmt.Errorf("%w: %v", ErrLocked, err)
+	case keychain.ErrorNotAvailable, keychain.ErrorNoSuchKeychain:
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return err
+}
+
+func (s *KeychainSecretStore) GetSecret(userID string) (string, error) {
+	data, err := keychain.GetGenericPassword(service, userID, "", "")
+	if err != nil {
+		return "", keychainError(err)
+	}
+	// GetGenericPassword returns nil, nil if there is no item.
+	if data == nil {
+		return "", ErrNotFound
+	}
+	return string(data), nil
 }
 
-func (s *KeychainSecretStore) SetSecret(key string, value string) error {
-	item := keychain.NewItem()
-	item.SetSecClass(keychain.SecClassGenericPassword)
-	item.SetService(service)
-	item.SetAccount("gabriel")
-	item.SetLabel("A label")
-	item.SetAccessGroup(service)
-	item.SetData([]byte(key))
+func (s *KeychainSecretStore) SetSecret(userID string, value string) error {
+	item := keychain.NewGenericPassword(service, userID, "Bitwarden biometrics key", []byte(value), "")
 	item.SetSynchronizable(keychain.SynchronizableNo)
 	item.SetAccessible(keychain.AccessibleWhenUnlocked)
 	err := keychain.AddItem(item)
-
 	if err == keychain.ErrorDuplicateItem {
-		errors.New("Key is already stored")
+		query := keychain.NewItem()
+		query.SetSecClass(keychain.SecClassGenericPassword)
+		query.SetService(service)
+		query.SetAccount(userID)
+		update := keychain.NewItem()
+		update.SetData([]byte(value))
+		err = keychain.UpdateItem(query, update)
+	}
+	if err != nil {
+		return keychainError(err)
 	}
 	return nil
 }
 
-func (s *KeychainSecretStore) DeleteSecret(key string) error {
-	return errors.New("Not implemented on OSX")
+func (s *KeychainSecretStore) DeleteSecret(userID string) error {
+	err := keychain.DeleteGenericPasswordItem(service, userID)
+	if err == keychain.ErrorItemNotFound {
+		return nil
+	} else if err != nil {
+		return keychainError(err)
+	}
+	return nil
 }
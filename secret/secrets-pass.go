@@ -0,0 +1,267 @@
+package secret
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/quexten/bw-bio-handler/internal/lockedfile"
+)
+
+// passFolder is the folder in the password store holding the entries.
+const passFolder = "bw-bio-handler"
+
+// ErrPinRequired is returned when gpg-agent could not obtain the passphrase
+// or smartcard PIN, f.e. because no pinentry could be started or it was
+// canceled. It wraps ErrLocked.
+var ErrPinRequired = fmt.Errorf("%w: gpg-agent could not obtain the PIN or passphrase", ErrLocked)
+
+// gpg-error codes, the lower 16 bits of the ERROR status values, that mean
+// the secret key is there but couldn't be unlocked.
+var pinErrorCodes = map[int]bool{
+	11: true, // bad passphrase
+	85: true, // no pinentry
+	86: true, // pinentry error
+	87: true, // bad PIN
+	99: true, // canceled
+}
+
+// PassSecretStore keeps the secrets in a password store, as managed by pass
+// (https://www.passwordstore.org). Entries are encrypted with the gpg binary
+// to the recipients in the closest .gpg-id file, so they can also be read
+// with `pass show bw-bio-handler/<user id>`. Writes lock the store, as
+// every browser starts its own handler.
+type PassSecretStore struct {
+	mu      sync.Mutex
+	dir     string
+	gpg     string
+	gpgOpts []string
+}
+
+// DefaultPassDir returns $PASSWORD_STORE_DIR, or ~/.password-store.
+func DefaultPassDir() (string, error) {
+	if dir := os.Getenv("PASSWORD_STORE_DIR"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".password-store"), nil
+}
+
+// NewPassSecretStore opens the password store in dir, which must have been
+// initialized with `pass init`. Like pass, gpg2 is preferred over gpg and
+// $PASSWORD_STORE_GPG_OPTS is passed on to it.
+func NewPassSecretStore(dir string) (*PassSecretStore, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".gpg-id")); err != nil {
+		return nil, fmt.Errorf("%w: password store %s is not initialized, run pass init: %v", ErrUnavailable, dir, err)
+	}
+	gpg, err := exec.LookPath("gpg2")
+	if err != nil {
+		if gpg, err = exec.LookPath("gpg"); err != nil {
+			return nil, fmt.Errorf("%w: gpg not found in $PATH", ErrUnavailable)
+		}
+	}
+	return &PassSecretStore{
+		dir:     dir,
+		gpg:     gpg,
+		gpgOpts: strings.Fields(os.Getenv("PASSWORD_STORE_GPG_OPTS")),
+	}, nil
+}
+
+func init() {
+	register("pass", 15, openPassStore)
+}
+
+func openPassStore(explicit bool, opts Options) (SecretStore, error) {
+	dir, err := DefaultPassDir()
+	if err != nil {
+		return nil, err
+	}
+	return NewPassSecretStore(dir)
+}
+
+// lock serializes changes of the entries with the other goroutines and
+// handlers. The lock file is hidden in the store root, so that pass doesn't
+// list it.
+func (s *PassSecretStore) lock() (func(), error) {
+	s.mu.Lock()
+	unlock, err := lockedfile.Lock(filepath.Join(s.dir, "."+passFolder))
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *PassSecretStore) entryPath(userID string) (string, error) {
+	if userID == "" || strings.ContainsAny(userID, `/\`) || strings.HasPrefix(userID, ".") {
+		return "", fmt.Errorf("invalid password store entry name %q", userID)
+	}
+	return filepath.Join(s.dir, passFolder, userID+".gpg"), nil
+}
+
+// recipients returns the keys to encrypt the entry at path to: those in
+// $PASSWORD_STORE_KEY, or the closest .gpg-id file up to the store root.
+func (s *PassSecretStore) recipients(path string) ([]string, error) {
+	if keys := strings.Fields(os.Getenv("PASSWORD_STORE_KEY")); len(keys) > 0 {
+		return keys, nil
+	}
+	dir := filepath.Dir(path)
+	for {
+		bs, err := os.ReadFile(filepath.Join(dir, ".gpg-id"))
+		if err == nil {
+			return parseGPGID(bs), nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if dir == filepath.Clean(s.dir) || dir == filepath.Dir(dir) {
+			return nil, fmt.Errorf("no .gpg-id in %s", s.dir)
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// parseGPGID returns the key IDs of a .gpg-id file, one per line, with
+// comments stripped.
+func parseGPGID(bs []byte) []string {
+	var ids []string
+	scanner := bufio.NewScanner(bytes.NewReader(bs))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			ids = append(ids, line)
+		}
+	}
+	return ids
+}
+
+// runGPG runs gpg with the status output on stderr, so that failures to
+// unlock the secret key can be told apart from other errors. --quiet can't be
+// used, as it suppresses the status of failed public key decryptions.
+func (s *PassSecretStore) runGPG(stdin []byte, args ...string) ([]byte, error) {
+	args = append(append([]string{"--yes", "--batch", "--status-fd=2", "--compress-algo=none", "--no-encrypt-to"}, s.gpgOpts...), args...)
+	cmd := exec.Command(s.gpg, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, gpgError(stderr.Bytes(), err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// gpgError turns the stderr output of a failed gpg run into an error, which
+// wraps ErrPinRequired when the secret key couldn't be unlocked.
+func gpgError(stderr []byte, runErr error) error {
+	pin := false
+	var messages []string
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "[GNUPG:] ") {
+			// Errors are of the form "gpg: <what>: <error>", the other
+			// lines are informational.
+			if msg := strings.TrimPrefix(line, "gpg: "); msg != line && strings.Contains(msg, ": ") {
+				messages = append(messages, msg)
+			}
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "[GNUPG:] "))
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "SC_OP_FAILURE":
+			pin = true
+		case fields[0] == "ERROR" && len(fields) >= 3:
+			if code, err := strconv.Atoi(fields[2]); err == nil && pinErrorCodes[code&0xffff] {
+				pin = true
+			}
+		}
+	}
+	msg := runErr.Error()
+	if len(messages) > 0 {
+		msg = strings.Join(messages, "; ")
+	}
+	if pin {
+		return fmt.Errorf("%w: %s", ErrPinRequired, msg)
+	}
+	return fmt.Errorf("gpg failed: %s", msg)
+}
+
+func (s *PassSecretStore) GetSecret(userID string) (string, error) {
+	path, err := s.entryPath(userID)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	plaintext, err := s.runGPG(nil, "--decrypt", path)
+	if err != nil {
+		return "", err
+	}
+	// Like `pass show -c`, only the first line is the secret.
+	value, _, _ := strings.Cut(string(plaintext), "\n")
+	return value, nil
+}
+
+func (s *PassSecretStore) SetSecret(userID string, value string) error {
+	if strings.Contains(value, "\n") {
+		return errors.New("password store entries must be a single line")
+	}
+	path, err := s.entryPath(userID)
+	if err != nil {
+		return err
+	}
+	recipients, err := s.recipients(path)
+	if err != nil {
+		return err
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	args := []string{"--encrypt", "--output", path}
+	for _, r := range recipients {
+		args = append(args, "--recipient", r)
+	}
+	_, err = s.runGPG([]byte(value+"\n"), args...)
+	return err
+}
+
+func (s *PassSecretStore) DeleteSecret(userID string) error {
+	path, err := s.entryPath(userID)
+	if err != nil {
+		return err
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// pass removes folders that became empty, so does this.
+	_ = os.Remove(filepath.Dir(path))
+	return nil
+}
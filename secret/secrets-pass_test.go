@@ -0,0 +1,89 @@
+package secret_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/quexten/bw-bio-handler/secret"
+	"github.com/quexten/bw-bio-handler/secret/secrettest"
+)
+
+// newPassStore creates a password store in a temporary directory, with a
+// throwaway GNUPGHOME holding a test key protected by passphrase.
+func newPassStore(t *testing.T, passphrase string) (*secret.PassSecretStore, string) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not available")
+	}
+	// gpg-agent's socket path must be short, so t.TempDir is too long.
+	gnupgHome, err := os.MkdirTemp("", "gnupg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GNUPGHOME", gnupgHome)
+	t.Cleanup(func() {
+		exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+		os.RemoveAll(gnupgHome)
+	})
+	t.Setenv("PASSWORD_STORE_KEY", "")
+	t.Setenv("PASSWORD_STORE_GPG_OPTS", "")
+
+	const uid = "bw-bio-handler test <test@example.com>"
+	gen := exec.Command("gpg", "--batch", "--pinentry-mode", "loopback", "--passphrase", passphrase,
+		"--quick-gen-key", uid, "future-default", "default", "never")
+	if out, err := gen.CombinedOutput(); err != nil {
+		t.Fatalf("could not generate test key: %v\n%s", err, out)
+	}
+
+	dir := filepath.Join(t.TempDir(), "password-store")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".gpg-id"), []byte("test@example.com # the test key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := secret.NewPassSecretStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, dir
+}
+
+func TestPassStoreFunctionality(t *testing.T) {
+	store, dir := newPassStore(t, "")
+	secrettest.TestStore(t, store)
+
+	if err := store.SetSecret("bw-bio-test", "test"); err != nil {
+		t.Fatal(err)
+	}
+	// The entry must be readable by pass, i.e. plain gpg.
+	out, err := exec.Command("gpg", "--batch", "--quiet", "--decrypt", filepath.Join(dir, "bw-bio-handler", "bw-bio-test.gpg")).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(out)) != "test" {
+		t.Fatalf("gpg decrypted %q", out)
+	}
+}
+
+func TestPassStorePinRequired(t *testing.T) {
+	store, dir := newPassStore(t, "passphrase")
+	if err := store.SetSecret("bw-bio-test", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Forget the passphrase cached while generating the key, and make sure
+	// no pinentry is started.
+	exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+	t.Setenv("PASSWORD_STORE_GPG_OPTS", "--pinentry-mode=error")
+	store, err := secret.NewPassSecretStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetSecret("bw-bio-test"); !errors.Is(err, secret.ErrPinRequired) {
+		t.Fatalf("expected ErrPinRequired, got %v", err)
+	}
+}
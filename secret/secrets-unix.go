@@ -3,71 +3,413 @@
 package secret
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/keybase/dbus"
 	"github.com/keybase/go-keychain/secretservice"
 )
 
-const colletion = dbus.ObjectPath(secretservice.DefaultCollection)
+// defaultCollectionName is the collection the keys are stored in, unless
+// another one is configured. "default" selects the default collection,
+// usually the login keyring, where keys were stored before.
+const defaultCollectionName = "bw-bio-handler"
+
+// unlockTimeout is how long to wait for the user to answer the prompt for
+// unlocking the keyring. The prompt is dismissed afterwards.
+const unlockTimeout = 30 * time.Second
+
+func init() {
+	register("secret-service", 30, func(explicit bool, opts Options) (SecretStore, error) {
+		collection := opts.Collection
+		if collection == "" {
+			collection = defaultCollectionName
+		}
+		return newSecretServiceStore(collection)
+	})
+}
 
-func GetStore() (SecretStore, error) {
+func newSecretServiceStore(collection string) (*SecretServiceSecretStore, error) {
 	service, err := secretservice.NewService()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
 	}
-	colletions := []dbus.ObjectPath{dbus.ObjectPath(secretservice.DefaultCollection)}
-	service.Unlock(colletions)
 	session, err := service.OpenSession(secretservice.AuthenticationDHAES)
 	if err != nil {
-		return nil, err
+		return nil, secretServiceError(err)
 	}
 
 	return &SecretServiceSecretStore{
-		service: service,
-		session: session,
+		service:        service,
+		session:        session,
+		collectionName: collection,
 	}, nil
 }
 
+// SecretServiceSecretStore keeps the secrets in a dedicated collection of
+// the Secret Service, which is created on the first write. Keys stored in the
+// default collection by older versions are still found, and moved when they
+// are replaced.
 type SecretServiceSecretStore struct {
 	service *secretservice.SecretService
 	session *secretservice.Session
+
+	collectionName string
+
+	// mu serializes prompts, as only one can be awaited at a time.
+	mu sync.Mutex
 }
 
-func (s *SecretServiceSecretStore) GetSecret(key string) (string, error) {
-	item, _ := s.service.SearchCollection(colletion, map[string]string{"account": key})
-	if len(item) > 0 {
-		secret, err := s.service.GetSecret(item[0], *s.session)
+// secretServiceError maps the D-Bus errors of the Secret Service API to
+// ErrLocked and ErrUnavailable.
+func secretServiceError(err error) error {
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) {
+		return err
+	}
+	switch dbusErr.Name {
+	case "org.freedesktop.Secret.Error.IsLocked":
+		return fmt.Errorf("%w: %v", ErrLocked, err)
+	case "org.freedesktop.DBus.Error.ServiceUnknown", "org.freedesktop.DBus.Error.NoReply", "org.freedesktop.DBus.Error.Disconnected":
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return err
+}
+
+// collection returns the path of the collection holding the keys. It is
+// looked up by alias and then by label. If there is none, it is created when
+// create is set, and "" is returned otherwise.
+func (s *SecretServiceSecretStore) collection(create bool) (dbus.ObjectPath, error) {
+	if s.collectionName == "default" {
+		return secretservice.DefaultCollection, nil
+	}
+
+	var path dbus.ObjectPath
+	err := s.service.ServiceObj().
+		Call("org.freedesktop.Secret.Service.ReadAlias", secretservice.NilFlags, s.collectionName).
+		Store(&path)
+	if err != nil {
+		return "", secretServiceError(err)
+	}
+	if path != secretservice.NullPrompt {
+		return path, nil
+	}
+
+	collections, err := s.service.ServiceObj().GetProperty("org.freedesktop.Secret.Service.Collections")
+	if err != nil {
+		return "", secretServiceError(err)
+	}
+	paths, _ := collections.Value().([]dbus.ObjectPath)
+	for _, path := range paths {
+		label, err := s.service.Obj(path).GetProperty("org.freedesktop.Secret.Collection.Label")
+		if err == nil && label.Value() == s.collectionName {
+			return path, nil
+		}
+	}
+	if !create {
+		return "", nil
+	}
+
+	// Creating a collection usually prompts for its password.
+	var prompt dbus.ObjectPath
+	properties := map[string]dbus.Variant{
+		"org.freedesktop.Secret.Collection.Label": dbus.MakeVariant(s.collectionName),
+	}
+	err = s.service.ServiceObj().
+		Call("org.freedesktop.Secret.Service.CreateCollection", secretservice.NilFlags, properties, s.collectionName).
+		Store(&path, &prompt)
+	if err != nil {
+		return "", secretServiceError(err)
+	}
+	if prompt == secretservice.NullPrompt {
+		return path, nil
+	}
+	result, err := s.awaitPrompt(prompt, "keyring creation")
+	if err != nil {
+		return "", err
+	}
+	if path, ok := result.Value().(dbus.ObjectPath); ok && path != secretservice.NullPrompt {
+		return path, nil
+	}
+	return "", fmt.Errorf("creating the %s keyring returned no collection", s.collectionName)
+}
+
+// awaitPrompt shows the prompt and waits for its result for at most
+// unlockTimeout. A dismissed or unanswered prompt results in ErrLocked.
+func (s *SecretServiceSecretStore) awaitPrompt(prompt dbus.ObjectPath, what string) (*dbus.Variant, error) {
+	type promptResult struct {
+		paths *dbus.Variant
+		err   error
+	}
+	done := make(chan promptResult, 1)
+	go func() {
+		paths, err := s.service.PromptAndWait(prompt)
+		done <- promptResult{paths, err}
+	}()
+
+	var res promptResult
+	select {
+	case res = <-done:
+	case <-time.After(unlockTimeout):
+		s.service.Obj(prompt).Call("org.freedesktop.Secret.Prompt.Dismiss", secretservice.NilFlags)
+		<-done
+		return nil, fmt.Errorf("%w: %s prompt timed out after %v", ErrLocked, what, unlockTimeout)
+	}
+	var dismissed secretservice.PromptDismissedError
+	if errors.As(res.err, &dismissed) {
+		return nil, fmt.Errorf("%w: %s prompt was dismissed", ErrLocked, what)
+	} else if res.err != nil {
+		return nil, fmt.Errorf("%w: %s failed: %v", ErrLocked, what, res.err)
+	}
+	if res.paths == nil {
+		return &dbus.Variant{}, nil
+	}
+	return res.paths, nil
+}
+
+// unlock makes sure the collection is unlocked. If it is locked, the Secret
+// Service is asked to unlock it, which usually shows a password prompt.
+func (s *SecretServiceSecretStore) unlock(collection dbus.ObjectPath) error {
+	locked, err := s.service.Obj(collection).GetProperty("org.freedesktop.Secret.Collection.Locked")
+	if err != nil {
+		return secretServiceError(err)
+	}
+	if isLocked, ok := locked.Value().(bool); ok && !isLocked {
+		return nil
+	}
+
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err = s.service.ServiceObj().
+		Call("org.freedesktop.Secret.Service.Unlock", secretservice.NilFlags, []dbus.ObjectPath{collection}).
+		Store(&unlocked, &prompt)
+	if err != nil {
+		return secretServiceError(err)
+	}
+	if prompt == secretservice.NullPrompt {
+		return nil
+	}
+	_, err = s.awaitPrompt(prompt, "keyring unlock")
+	return err
+}
+
+// search returns the items of the user in the collection. Matching the
+// application as well keeps items of other applications with an "account"
+// attribute out.
+func (s *SecretServiceSecretStore) search(collection dbus.ObjectPath, userID string) ([]dbus.ObjectPath, error) {
+	items, err := s.service.SearchCollection(collection, map[string]string{"application": service, "account": userID})
+	if err != nil {
+		return nil, secretServiceError(err)
+	}
+	return items, nil
+}
+
+// legacyItems returns the items of the user stored by older versions in the
+// default collection. These only have the "account" attribute, so the items
+// of other applications are told apart by the label older versions used.
+// Searching doesn't need the collection to be unlocked.
+func (s *SecretServiceSecretStore) legacyItems(userID string) ([]dbus.ObjectPath, error) {
+	items, err := s.service.SearchCollection(secretservice.DefaultCollection, map[string]string{"account": userID})
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) && dbusErr.Name == "org.freedesktop.Secret.Error.NoSuchObject" {
+		// There is no default collection.
+		return nil, nil
+	} else if err != nil {
+		return nil, secretServiceError(err)
+	}
+	var legacy []dbus.ObjectPath
+	for _, item := range items {
+		attributes, err := s.service.GetAttributes(item)
 		if err != nil {
-			return "", err
+			return nil, secretServiceError(err)
+		}
+		label, err := s.service.Obj(item).GetProperty("org.freedesktop.Secret.Item.Label")
+		if err != nil {
+			return nil, secretServiceError(err)
+		}
+		if _, ok := attributes["application"]; !ok && label.Value() == service {
+			legacy = append(legacy, item)
+		}
+	}
+	return legacy, nil
+}
+
+// items returns the items of the user and the collection holding them,
+// falling back to legacy items in the default collection.
+func (s *SecretServiceSecretStore) items(userID string) (dbus.ObjectPath, []dbus.ObjectPath, error) {
+	collection, err := s.collection(false)
+	if err != nil {
+		return "", nil, err
+	}
+	var items []dbus.ObjectPath
+	if collection != "" {
+		if items, err = s.search(collection, userID); err != nil {
+			return "", nil, err
+		}
+	}
+	if len(items) == 0 {
+		if items, err = s.legacyItems(userID); err != nil {
+			return "", nil, err
+		}
+		collection = secretservice.DefaultCollection
+	}
+	if len(items) == 0 {
+		return "", nil, ErrNotFound
+	}
+	return collection, items, nil
+}
+
+func (s *SecretServiceSecretStore) GetSecret(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	collection, items, err := s.items(key)
+	if err != nil {
+		return "", err
+	}
+	if err := s.unlock(collection); err != nil {
+		return "", err
+	}
+	secret, err := s.service.GetSecret(items[0], *s.session)
+	if err != nil {
+		return "", secretServiceError(err)
+	}
+	// GetSecret returns nil when the secret can't be decrypted.
+	if secret == nil {
+		return "", errors.New("failed to decrypt secret")
+	}
+	return string(secret), nil
+}
+
+// GetMetadata returns the label and attributes of the item of the user,
+// without the attributes used for lookups. Reading them doesn't need the
+// collection to be unlocked.
+func (s *SecretServiceSecretStore) GetMetadata(userId string) (Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, items, err := s.items(userId)
+	if err != nil {
+		return Metadata{}, err
+	}
+	attributes, err := s.service.GetAttributes(items[0])
+	if err != nil {
+		return Metadata{}, secretServiceError(err)
+	}
+	label, err := s.service.Obj(items[0]).GetProperty("org.freedesktop.Secret.Item.Label")
+	if err != nil {
+		return Metadata{}, secretServiceError(err)
+	}
+	meta := Metadata{Attributes: map[string]string{}}
+	meta.Label, _ = label.Value().(string)
+	for k, v := range attributes {
+		if k != "application" && k != "account" {
+			meta.Attributes[k] = v
 		}
-		return string(secret), nil
 	}
-	return "", nil
+	return meta, nil
 }
 
 func (s *SecretServiceSecretStore) SetSecret(userId string, key string) error {
-	secret, err := s.session.NewSecret([]byte(key))
+	return s.SetSecretWithMetadata(userId, key, Metadata{})
+}
+
+// SetSecretWithMetadata stores the secret as an item labeled meta.Label, with
+// meta.Attributes in addition to the account attribute used for lookups.
+// Previous items of the user are replaced.
+func (s *SecretServiceSecretStore) SetSecretWithMetadata(userId string, key string, meta Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	collection, err := s.collection(true)
 	if err != nil {
 		return err
 	}
-	_, err = s.service.CreateItem(colletion, secretservice.NewSecretProperties(service, map[string]string{"account": userId}), secret, secretservice.ReplaceBehaviorReplace)
+	if err := s.unlock(collection); err != nil {
+		return err
+	}
+
+	secret, err := s.session.NewSecret([]byte(key))
 	if err != nil {
 		return err
 	}
-	return nil
+	attributes := map[string]string{}
+	for k, v := range meta.Attributes {
+		attributes[k] = v
+	}
+	attributes["application"] = service
+	attributes["account"] = userId
+	label := meta.Label
+	if label == "" {
+		label = service
+	}
+
+	// Items are only replaced if all attributes match, so delete the old
+	// ones first.
+	if err := s.deleteItems(collection, userId); err != nil {
+		return err
+	}
+	_, err = s.service.CreateItem(collection, secretservice.NewSecretProperties(label, attributes), secret, secretservice.ReplaceBehaviorReplace)
+	if err != nil {
+		return secretServiceError(err)
+	}
+
+	return s.deleteLegacyItems(userId)
 }
 
-func (s *SecretServiceSecretStore) DeleteSecret(userId string) error {
-	items, err := s.service.SearchCollection(colletion, map[string]string{"account": userId})
+// deleteItems deletes the items of the user in the collection, unlocking it
+// if there are any.
+func (s *SecretServiceSecretStore) deleteItems(collection dbus.ObjectPath, userId string) error {
+	items, err := s.search(collection, userId)
 	if err != nil {
 		return err
 	}
+	return s.removeItems(collection, items)
+}
+
+// removeItems deletes the items of the collection, unlocking it if there are
+// any.
+func (s *SecretServiceSecretStore) removeItems(collection dbus.ObjectPath, items []dbus.ObjectPath) error {
+	if len(items) == 0 {
+		return nil
+	}
+	if err := s.unlock(collection); err != nil {
+		return err
+	}
 
 	for _, item := range items {
 		err := s.service.DeleteItem(item)
 		if err != nil {
-			return err
+			return secretServiceError(err)
 		}
 	}
 
 	return nil
 }
+
+// deleteLegacyItems deletes the items of the user stored by older versions.
+func (s *SecretServiceSecretStore) deleteLegacyItems(userId string) error {
+	legacy, err := s.legacyItems(userId)
+	if err != nil {
+		return err
+	}
+	return s.removeItems(secretservice.DefaultCollection, legacy)
+}
+
+func (s *SecretServiceSecretStore) DeleteSecret(userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	collection, err := s.collection(false)
+	if err != nil {
+		return err
+	}
+	if collection != "" {
+		if err := s.deleteItems(collection, userId); err != nil {
+			return err
+		}
+	}
+	return s.deleteLegacyItems(userId)
+}
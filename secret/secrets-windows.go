@@ -3,15 +3,25 @@
 package secret
 
 import (
+	"errors"
+
 	"github.com/danieljoos/wincred"
 )
 
+func init() {
+	register("wincred", 30, func(explicit bool, opts Options) (SecretStore, error) {
+		return &WindowsSecretStore{}, nil
+	})
+}
+
 type WindowsSecretStore struct {
 }
 
 func (s *WindowsSecretStore) GetSecret(userID string) (string, error) {
 	cred, err := wincred.GetGenericCredential(service + "-" + userID)
-	if err == nil {
+	if errors.Is(err, wincred.ErrElementNotFound) {
+		return "", ErrNotFound
+	} else if err != nil {
 		return "", err
 	}
 
@@ -28,10 +38,10 @@ func (s *WindowsSecretStore) SetSecret(userID string, key string) error {
 
 func (s *WindowsSecretStore) DeleteSecret(userID string) error {
 	cred, err := wincred.GetGenericCredential(service + "-" + userID)
-	if err != nil {
+	if errors.Is(err, wincred.ErrElementNotFound) {
+		return nil
+	} else if err != nil {
 		return err
 	}
-	cred.Delete()
-
-	return nil
+	return cred.Delete()
 }
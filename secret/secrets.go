@@ -1,9 +1,210 @@
 package secret
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
 const service = "com.quexten.bitwarden-biometrics-handler"
 
+// Errors returned by every SecretStore, possibly wrapped, so check them with
+// errors.Is.
+var (
+	// ErrNotFound is returned by GetSecret when there is no secret for the
+	// user.
+	ErrNotFound = errors.New("secret not found")
+	// ErrLocked is returned when the store exists, but could not be unlocked,
+	// f.e. because the unlock prompt was dismissed or a passphrase is wrong.
+	ErrLocked = errors.New("secret store is locked")
+	// ErrUnavailable is returned when the store can't be used at all, f.e.
+	// because its daemon isn't running.
+	ErrUnavailable = errors.New("secret store unavailable")
+)
+
+// SecretStore stores a secret per user. GetSecret returns ErrNotFound for
+// users without a secret, DeleteSecret succeeds for them.
 type SecretStore interface {
 	GetSecret(userID string) (string, error)
 	SetSecret(userID string, value string) error
 	DeleteSecret(userID string) error
 }
+
+// Metadata describes a secret, for stores that show their entries to the
+// user, like Seahorse does for the Secret Service.
+type Metadata struct {
+	// Label is a human readable name of the secret.
+	Label string
+	// Attributes are searchable properties, f.e. the server URL.
+	Attributes map[string]string
+}
+
+// MetadataStore is implemented by stores that can keep metadata along with
+// the secrets.
+type MetadataStore interface {
+	SecretStore
+	SetSecretWithMetadata(userID string, value string, meta Metadata) error
+	// GetMetadata returns the metadata of the secret, or ErrNotFound.
+	GetMetadata(userID string) (Metadata, error)
+}
+
+// SetSecretWithMetadata stores the secret along with meta if store supports
+// it, and only the secret otherwise.
+func SetSecretWithMetadata(store SecretStore, userID string, value string, meta Metadata) error {
+	if s, ok := store.(MetadataStore); ok {
+		return s.SetSecretWithMetadata(userID, value, meta)
+	}
+	return store.SetSecret(userID, value)
+}
+
+// GetMetadata returns the metadata of the secret if store keeps it, and
+// empty metadata otherwise.
+func GetMetadata(store SecretStore, userID string) (Metadata, error) {
+	if s, ok := store.(MetadataStore); ok {
+		return s.GetMetadata(userID)
+	}
+	return Metadata{}, nil
+}
+
+// Options configure the backends. Empty fields select the defaults.
+type Options struct {
+	// Collection is the Secret Service collection the keys are kept in,
+	// "default" for the default collection.
+	Collection string
+
+	// FilePath is the location of the file backend's secret file,
+	// DefaultFilePath if empty.
+	FilePath string
+	// FileKeyFile is the key file the file backend derives its key from.
+	FileKeyFile string
+	// FilePassphrase is the passphrase the file backend derives its key
+	// from when no key file is set.
+	FilePassphrase string
+}
+
+// DataDir returns the directory for the state of the handler, following the
+// XDG base directory specification.
+func DataDir() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "bw-bio-handler"), nil
+}
+
+// backend is a named way of storing secrets.
+type backend struct {
+	name string
+	// priority orders the automatic fallback. Backends with a negative
+	// priority are only used when selected explicitly.
+	priority int
+	// open is told whether the backend was selected explicitly, so that
+	// backends needing configuration can pick defaults.
+	open func(explicit bool, opts Options) (SecretStore, error)
+}
+
+var backends []backend
+
+// register makes a backend available. It is called from the init functions
+// of the backends supported on the platform.
+func register(name string, priority int, open func(explicit bool, opts Options) (SecretStore, error)) {
+	backends = append(backends, backend{name: name, priority: priority, open: open})
+	sort.SliceStable(backends, func(i, j int) bool {
+		return backends[i].priority > backends[j].priority
+	})
+}
+
+func findBackend(name string) (backend, bool) {
+	for _, b := range backends {
+		if b.name == name {
+			return b, true
+		}
+	}
+	return backend{}, false
+}
+
+// Backends returns the names of the backends available on this platform, in
+// fallback order.
+func Backends() []string {
+	names := make([]string, len(backends))
+	for i, b := range backends {
+		names[i] = b.name
+	}
+	return names
+}
+
+// Open opens the first available backend out of preferred, a comma
+// separated list of backend names. Only when preferred is empty, the
+// backends are tried in the automatic fallback order, so that a configured
+// backend that fails isn't silently replaced by another. It returns the name
+// of the backend that was opened.
+func Open(preferred string, opts Options) (SecretStore, string, error) {
+	var order []backend
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(preferred, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		b, ok := findBackend(name)
+		if !ok {
+			return nil, "", fmt.Errorf("unknown secret backend %q, available are: %s", name, strings.Join(Backends(), ", "))
+		}
+		seen[name] = true
+		order = append(order, b)
+	}
+	explicit := len(order)
+	if explicit == 0 {
+		for _, b := range backends {
+			if b.priority >= 0 {
+				order = append(order, b)
+			}
+		}
+	}
+
+	var errs []string
+	for i, b := range order {
+		store, err := b.open(i < explicit, opts)
+		if err == nil {
+			return store, b.name, nil
+		}
+		errs = append(errs, b.name+": "+err.Error())
+	}
+	if len(errs) == 0 {
+		return nil, "", ErrUnavailable
+	}
+	return nil, "", fmt.Errorf("no secret backend could be opened (%s): %w", strings.Join(errs, "; "), ErrUnavailable)
+}
+
+// OpenBackend opens the backend with the given name, without falling back
+// to others.
+func OpenBackend(name string, opts Options) (SecretStore, error) {
+	b, ok := findBackend(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown secret backend %q, available are: %s", name, strings.Join(Backends(), ", "))
+	}
+	store, err := b.open(true, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return store, nil
+}
+
+// GetStore opens the backends selected by $BW_BIO_SECRET_BACKEND, or the
+// platform default, configured from the environment.
+func GetStore() (SecretStore, error) {
+	store, _, err := Open(os.Getenv("BW_BIO_SECRET_BACKEND"), Options{
+		Collection:     os.Getenv("BW_BIO_SECRET_COLLECTION"),
+		FilePath:       os.Getenv("BW_BIO_FILE_PATH"),
+		FileKeyFile:    os.Getenv("BW_BIO_FILE_KEYFILE"),
+		FilePassphrase: os.Getenv("BW_BIO_FILE_PASSPHRASE"),
+	})
+	return store, err
+}
@@ -1,42 +1,103 @@
 package secret_test
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/quexten/bw-bio-handler/secret"
+	"github.com/quexten/bw-bio-handler/secret/secrettest"
 )
 
+// TestStoreFunctionality runs against the native store of the platform,
+// the first in the fallback order, which is skipped where it isn't
+// available, f.e. without a Secret Service.
 func TestStoreFunctionality(t *testing.T) {
-	store, err := secret.GetStore()
-	if err != nil {
+	store, err := secret.OpenBackend(secret.Backends()[0], secret.Options{})
+	if errors.Is(err, secret.ErrUnavailable) {
+		t.Skip(err)
+	} else if err != nil {
 		t.Fatal(err)
 	}
+	secrettest.TestStore(t, store)
+}
+
+func TestMemoryStoreFunctionality(t *testing.T) {
+	secrettest.TestStore(t, secret.NewMemorySecretStore())
+}
 
-	err = store.SetSecret("bw-bio-test", "test")
+func TestFileStoreFunctionality(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	store, err := secret.NewFileSecretStore(path, []byte("passphrase"))
 	if err != nil {
 		t.Fatal(err)
 	}
+	secrettest.TestStore(t, store)
+}
 
-	secret, err := store.GetSecret("bw-bio-test")
+func TestFileStoreWrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	store, err := secret.NewFileSecretStore(path, []byte("passphrase"))
 	if err != nil {
 		t.Fatal(err)
 	}
+	if err := store.SetSecret("bw-bio-test", "test"); err != nil {
+		t.Fatal(err)
+	}
 
-	if secret != "test" {
-		t.Fatal("Secret not equal to test")
+	if _, err := secret.NewFileSecretStore(path, []byte("wrong")); !errors.Is(err, secret.ErrLocked) {
+		t.Fatalf("Expected ErrLocked for the wrong passphrase, got %v", err)
 	}
 
-	err = store.DeleteSecret("bw-bio-test")
+	reopened, err := secret.NewFileSecretStore(path, []byte("passphrase"))
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	secret, err = store.GetSecret("bw-bio-test")
+	secret, err := reopened.GetSecret("bw-bio-test")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if secret != "test" {
+		t.Fatal("Secret not equal to test after reopening")
+	}
+}
+
+// TestFileStoreConcurrentWriters writes through two stores of the same file,
+// as two handlers would, and checks that no write is lost.
+func TestFileStoreConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	var stores []*secret.FileSecretStore
+	for i := 0; i < 2; i++ {
+		store, err := secret.NewFileSecretStore(path, []byte("passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, store)
+	}
+
+	const writes = 10
+	var wg sync.WaitGroup
+	for i, store := range stores {
+		for j := 0; j < writes; j++ {
+			wg.Add(1)
+			go func(store *secret.FileSecretStore, id string) {
+				defer wg.Done()
+				if err := store.SetSecret(id, id); err != nil {
+					t.Error(err)
+				}
+			}(store, fmt.Sprintf("bw-bio-test-%d-%d", i, j))
+		}
+	}
+	wg.Wait()
 
-	if secret != "" {
-		t.Fatal("Secret not empty after deletion")
+	for i := range stores {
+		for j := 0; j < writes; j++ {
+			id := fmt.Sprintf("bw-bio-test-%d-%d", i, j)
+			if value, err := stores[0].GetSecret(id); err != nil || value != id {
+				t.Errorf("GetSecret(%s) = %q, %v after concurrent writes", id, value, err)
+			}
+		}
 	}
 }
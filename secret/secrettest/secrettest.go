@@ -0,0 +1,196 @@
+// Package secrettest implements support for testing SecretStore
+// implementations and code using them.
+package secrettest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+// prefix is prepended to every user ID used by TestStore, so that it
+// doesn't touch real entries when run against a platform store.
+const prefix = "bw-bio-test-"
+
+// TestStore checks that store implements the SecretStore semantics: secrets
+// can be set, read, overwritten and deleted, missing secrets result in
+// secret.ErrNotFound, and concurrent use is safe. Stores implementing
+// secret.MetadataStore must return the metadata they were given. The store
+// is left without any of the test entries.
+func TestStore(t *testing.T, store secret.SecretStore) {
+	t.Helper()
+
+	t.Run("SetGet", func(t *testing.T) {
+		id := prefix + "set-get"
+		defer store.DeleteSecret(id)
+		mustSet(t, store, id, "value")
+		expect(t, store, id, "value")
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		id := prefix + "overwrite"
+		defer store.DeleteSecret(id)
+		mustSet(t, store, id, "old")
+		mustSet(t, store, id, "new")
+		expect(t, store, id, "new")
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		id := prefix + "delete"
+		mustSet(t, store, id, "value")
+		if err := store.DeleteSecret(id); err != nil {
+			t.Fatalf("DeleteSecret(%q): %v", id, err)
+		}
+		expectMissing(t, store, id)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		id := prefix + "missing"
+		expectMissing(t, store, id)
+		if err := store.DeleteSecret(id); err != nil {
+			t.Fatalf("DeleteSecret(%q) of a missing secret: %v", id, err)
+		}
+	})
+
+	t.Run("Independent", func(t *testing.T) {
+		a, b := prefix+"a", prefix+"b"
+		defer store.DeleteSecret(a)
+		defer store.DeleteSecret(b)
+		mustSet(t, store, a, "a")
+		mustSet(t, store, b, "b")
+		if err := store.DeleteSecret(a); err != nil {
+			t.Fatalf("DeleteSecret(%q): %v", a, err)
+		}
+		expectMissing(t, store, a)
+		expect(t, store, b, "b")
+	})
+
+	t.Run("Unicode", func(t *testing.T) {
+		for _, id := range []string{prefix + "ünïcødé", prefix + "日本語", prefix + "emoji-🔑"} {
+			mustSet(t, store, id, "value of "+id)
+			expect(t, store, id, "value of "+id)
+			if err := store.DeleteSecret(id); err != nil {
+				t.Fatalf("DeleteSecret(%q): %v", id, err)
+			}
+			expectMissing(t, store, id)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		const workers = 8
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*2)
+		shared := prefix + "concurrent-shared"
+		defer store.DeleteSecret(shared)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				id := fmt.Sprintf("%sconcurrent-%d", prefix, i)
+				value := fmt.Sprintf("value-%d", i)
+				if err := store.SetSecret(id, value); err != nil {
+					errs <- fmt.Errorf("SetSecret(%q): %v", id, err)
+					return
+				}
+				if got, err := store.GetSecret(id); err != nil || got != value {
+					errs <- fmt.Errorf("GetSecret(%q) = %q, %v, want %q", id, got, err, value)
+				}
+				if err := store.DeleteSecret(id); err != nil {
+					errs <- fmt.Errorf("DeleteSecret(%q): %v", id, err)
+				}
+				if err := store.SetSecret(shared, value); err != nil {
+					errs <- fmt.Errorf("SetSecret(%q): %v", shared, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+
+		got, err := store.GetSecret(shared)
+		if err != nil {
+			t.Fatalf("GetSecret(%q): %v", shared, err)
+		}
+		var values []string
+		for i := 0; i < workers; i++ {
+			if got == fmt.Sprintf("value-%d", i) {
+				return
+			}
+			values = append(values, fmt.Sprintf("value-%d", i))
+		}
+		t.Fatalf("GetSecret(%q) = %q after concurrent writes, want one of %q", shared, got, values)
+	})
+
+	if ms, ok := store.(secret.MetadataStore); ok {
+		t.Run("Metadata", func(t *testing.T) {
+			id := prefix + "metadata"
+			defer store.DeleteSecret(id)
+			meta := secret.Metadata{
+				Label:      "bw-bio-handler test",
+				Attributes: map[string]string{"email": "test@example.com"},
+			}
+			if err := ms.SetSecretWithMetadata(id, "value", meta); err != nil {
+				t.Fatalf("SetSecretWithMetadata(%q): %v", id, err)
+			}
+			expect(t, store, id, "value")
+			got, err := ms.GetMetadata(id)
+			if err != nil {
+				t.Fatalf("GetMetadata(%q): %v", id, err)
+			}
+			if got.Label != meta.Label || got.Attributes["email"] != meta.Attributes["email"] {
+				t.Fatalf("GetMetadata(%q) = %+v, want %+v", id, got, meta)
+			}
+			if _, err := ms.GetMetadata(prefix + "missing"); !errors.Is(err, secret.ErrNotFound) {
+				t.Fatalf("GetMetadata of a missing secret: expected ErrNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func mustSet(t *testing.T, store secret.SecretStore, id string, value string) {
+	t.Helper()
+	if err := store.SetSecret(id, value); err != nil {
+		t.Fatalf("SetSecret(%q): %v", id, err)
+	}
+}
+
+func expect(t *testing.T, store secret.SecretStore, id string, want string) {
+	t.Helper()
+	got, err := store.GetSecret(id)
+	if err != nil {
+		t.Fatalf("GetSecret(%q): %v", id, err)
+	}
+	if got != want {
+		t.Fatalf("GetSecret(%q) = %q, want %q", id, got, want)
+	}
+}
+
+func expectMissing(t *testing.T, store secret.SecretStore, id string) {
+	t.Helper()
+	if got, err := store.GetSecret(id); !errors.Is(err, secret.ErrNotFound) {
+		t.Fatalf("GetSecret(%q) = %q, %v, want secret.ErrNotFound", id, got, err)
+	}
+}
+
+// ErrorStore is a SecretStore failing every call with Err, f.e. to test the
+// handling of secret.ErrLocked.
+type ErrorStore struct {
+	Err error
+}
+
+func (s ErrorStore) GetSecret(userID string) (string, error) {
+	return "", s.Err
+}
+
+func (s ErrorStore) SetSecret(userID string, value string) error {
+	return s.Err
+}
+
+func (s ErrorStore) DeleteSecret(userID string) error {
+	return s.Err
+}
@@ -0,0 +1,57 @@
+package secret
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// wrapPrefix marks stored values that are wrapped with a key kept outside of
+// the store.
+const wrapPrefix = "bw-bio-wrapped:"
+
+// ErrWrongKey is returned by Unwrap when the value wasn't wrapped with the
+// given key.
+var ErrWrongKey = errors.New("secret is wrapped with another key")
+
+// NewWrappingKey returns a random key for Wrap.
+func NewWrappingKey() ([]byte, error) {
+	key := make([]byte, argon2KeyLen)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Wrap encrypts value with AES-256-GCM under key, with id as additional data
+// so that the wrapped value can't be moved to another entry.
+func Wrap(key []byte, id string, value string) (string, error) {
+	entry, err := sealEntry(key, value, id)
+	if err != nil {
+		return "", err
+	}
+	bs, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	return wrapPrefix + string(bs), nil
+}
+
+// Unwrap decrypts a value returned by Wrap, or returns ErrWrongKey.
+func Unwrap(key []byte, id string, wrapped string) (string, error) {
+	if !strings.HasPrefix(wrapped, wrapPrefix) {
+		return "", errors.New("secret is not wrapped")
+	}
+	var entry fileEntry
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(wrapped, wrapPrefix)), &entry); err != nil {
+		return "", fmt.Errorf("invalid wrapped secret: %v", err)
+	}
+	value, err := openEntry(key, entry, id)
+	if err != nil {
+		return "", ErrWrongKey
+	}
+	return value, nil
+}
@@ -0,0 +1,37 @@
+package secret_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/quexten/bw-bio-handler/secret"
+)
+
+func TestWrap(t *testing.T) {
+	key, err := secret.NewWrappingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := secret.NewWrappingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := secret.Wrap(key, "user:app", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := secret.Unwrap(other, "user:app", wrapped); !errors.Is(err, secret.ErrWrongKey) {
+		t.Fatalf("Expected ErrWrongKey for another key, got %v", err)
+	}
+	if _, err := secret.Unwrap(key, "user:other", wrapped); !errors.Is(err, secret.ErrWrongKey) {
+		t.Fatalf("Expected ErrWrongKey for another id, got %v", err)
+	}
+	value, err := secret.Unwrap(key, "user:app", wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "key" {
+		t.Fatalf("Unwrap() = %q, want key", value)
+	}
+}
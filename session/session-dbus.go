@@ -0,0 +1,87 @@
+//go:build linux || freebsd || openbsd || netbsd || dragonfly
+
+// Package session watches the desktop session for the screen being locked
+// and the system going to sleep.
+package session
+
+import (
+	"context"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// lockSignals are the signals telling that the screen is locked or the
+// system goes to sleep, when their first argument is true. Session.Lock of
+// logind has no arguments.
+var lockSignals = []struct {
+	iface  string
+	member string
+}{
+	{"org.freedesktop.login1.Manager", "PrepareForSleep"},
+	{"org.freedesktop.login1.Session", "Lock"},
+	{"org.freedesktop.ScreenSaver", "ActiveChanged"},
+	{"org.gnome.ScreenSaver", "ActiveChanged"},
+}
+
+// CanWatchLock reports whether WatchLock is implemented on this platform.
+func CanWatchLock() bool {
+	return true
+}
+
+// WatchLock calls locked whenever the screen is locked or the system goes
+// to sleep, until ctx is done. It watches logind on the system bus and the
+// screensaver on the session bus; it fails only if neither bus is available.
+func WatchLock(ctx context.Context, locked func()) error {
+	var conns []*dbus.Conn
+	system, systemErr := dbus.SystemBus()
+	if systemErr == nil {
+		conns = append(conns, system)
+	}
+	if sessionBus, err := dbus.SessionBus(); err == nil {
+		conns = append(conns, sessionBus)
+	} else if systemErr != nil {
+		return systemErr
+	}
+	return Watch(ctx, conns, locked)
+}
+
+// Watch is WatchLock on the given buses.
+func Watch(ctx context.Context, conns []*dbus.Conn, locked func()) error {
+	signals := make(chan *dbus.Signal, 16)
+	for _, conn := range conns {
+		for _, s := range lockSignals {
+			match := []dbus.MatchOption{dbus.WithMatchInterface(s.iface), dbus.WithMatchMember(s.member)}
+			if err := conn.AddMatchSignal(match...); err != nil {
+				return err
+			}
+			defer conn.RemoveMatchSignal(match...)
+		}
+		conn.Signal(signals)
+		defer conn.RemoveSignal(signals)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case sig := <-signals:
+			if isLock(sig) {
+				locked()
+			}
+		}
+	}
+}
+
+func isLock(sig *dbus.Signal) bool {
+	for _, s := range lockSignals {
+		if sig.Name != s.iface+"."+s.member {
+			continue
+		}
+		if len(sig.Body) == 0 {
+			return true
+		}
+		active, _ := sig.Body[0].(bool)
+		return active
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+//go:build linux || freebsd || openbsd || netbsd || dragonfly
+
+package session_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/quexten/bw-bio-handler/internal/dbustest"
+	"github.com/quexten/bw-bio-handler/session"
+)
+
+func TestWatch(t *testing.T) {
+	address := dbustest.PrivateBus(t)
+	var conns []*dbus.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := dbus.Connect(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	watcher, emitter := conns[0], conns[1]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	locked := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Watch(ctx, []*dbus.Conn{watcher}, func() { locked <- struct{}{} })
+	}()
+	// Wait for the watcher to subscribe.
+	time.Sleep(100 * time.Millisecond)
+
+	signals := []struct {
+		path   dbus.ObjectPath
+		name   string
+		values []interface{}
+		locked bool
+	}{
+		{"/org/freedesktop/ScreenSaver", "org.freedesktop.ScreenSaver.ActiveChanged", []interface{}{false}, false},
+		{"/org/freedesktop/login1", "org.freedesktop.login1.Manager.PrepareForSleep", []interface{}{false}, false},
+		{"/org/freedesktop/login1", "org.freedesktop.login1.Manager.PrepareForSleep", []interface{}{true}, true},
+		{"/org/freedesktop/login1/session/_32", "org.freedesktop.login1.Session.Lock", nil, true},
+		{"/org/gnome/ScreenSaver", "org.gnome.ScreenSaver.ActiveChanged", []interface{}{true}, true},
+		{"/org/freedesktop/ScreenSaver", "org.freedesktop.ScreenSaver.ActiveChanged", []interface{}{true}, true},
+	}
+	for _, s := range signals {
+		if err := emitter.Emit(s.path, s.name, s.values...); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-locked:
+			if !s.locked {
+				t.Errorf("%s%v reported as lock", s.name, s.values)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if s.locked {
+				t.Errorf("%s%v not reported as lock", s.name, s.values)
+			}
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build darwin || windows
+
+// Package session watches the desktop session for the screen being locked
+// and the system going to sleep.
+package session
+
+import (
+	"context"
+	"errors"
+)
+
+// CanWatchLock reports whether WatchLock is implemented on this platform.
+func CanWatchLock() bool {
+	return false
+}
+
+// WatchLock is not implemented on this platform yet.
+func WatchLock(ctx context.Context, locked func()) error {
+	return errors.New("watching the screen lock is not implemented on this platform")
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package session
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SleepTime returns how long the system has been asleep since it booted.
+// A change of it tells that the system slept in between, even if nobody
+// watched it go to sleep.
+func SleepTime() time.Duration {
+	var boot, mono unix.Timespec
+	if unix.ClockGettime(unix.CLOCK_BOOTTIME, &boot) != nil || unix.ClockGettime(unix.CLOCK_MONOTONIC, &mono) != nil {
+		return 0
+	}
+	return time.Duration(boot.Nano() - mono.Nano())
+}
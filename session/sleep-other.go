@@ -0,0 +1,11 @@
+//go:build !linux
+
+package session
+
+import "time"
+
+// SleepTime can't be told on this platform, so sleep is only noticed by
+// WatchLock.
+func SleepTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type switchResult struct {
+	Status  string   `json:"status"`
+	Error   string   `json:"error,omitempty"`
+	DryRun  bool     `json:"dryRun,omitempty"`
+	Target  string   `json:"target,omitempty"`
+	Changes []change `json:"changes,omitempty"`
+}
+
+// runSwitch flips the browsers between the official desktop app and
+// bw-bio-handler, without reinstalling either of them.
+func runSwitch(args []string) int {
+	var manifests manifestFlags
+	fs := flag.NewFlagSet("switch", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bw-bio-handler switch [flags] official|handler")
+		fs.PrintDefaults()
+	}
+	manifests.register(fs)
+	browserSelection := fs.String("browser", "", "comma separated browsers to switch, or \"all\" ("+strings.Join(browserNames(), ", ")+"); defaults to the installed ones")
+	dryRun := fs.Bool("dry-run", false, "only print what would be changed")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || (fs.Arg(0) != "official" && fs.Arg(0) != "handler") {
+		fs.Usage()
+		return 2
+	}
+
+	p := newPrinter(*jsonOutput)
+	changes := &changeSet{p: p, dryRun: *dryRun}
+	res := &switchResult{Status: "ok", DryRun: *dryRun, Target: fs.Arg(0)}
+	err := switchManifests(p, changes, &manifests, *browserSelection, fs.Arg(0))
+	res.Changes = changes.changes
+	if err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+		p.result(res)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	p.result(res)
+	return 0
+}
+
+func switchManifests(p *printer, changes *changeSet, manifestFlags *manifestFlags, browserSelection string, target string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("could not load config: %v", err)
+	}
+	manifestOpts := manifestFlags.resolve(cfg, handlerPath())
+
+	home := os.Getenv("HOME")
+	selected, err := selectBrowsers(browserSelection, home)
+	if err != nil {
+		return err
+	}
+
+	switch target {
+	case "official":
+		// The backups are kept, so that switching back and forth doesn't
+		// lose them.
+		restored := 0
+		seen := make(map[string]bool)
+		for _, b := range selected {
+			path := b.manifestPath(home, manifestOpts.fileName())
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			ok, err := restoreManifest(p, changes, b, manifestOpts.fileName(), true)
+			if err != nil {
+				return fmt.Errorf("failed to restore backup for %s: %v", b.displayName, err)
+			}
+			if ok {
+				restored++
+			}
+		}
+		if restored == 0 {
+			return fmt.Errorf("no backed up manifest of the official desktop app found")
+		}
+	case "handler":
+		planned, err := planManifests(selected, home, manifestOpts)
+		if err != nil {
+			return err
+		}
+		if _, err := installManifests(p, changes, planned, manifestOpts.hostName); err != nil {
+			return fmt.Errorf("failed to install browser manifests: %v", err)
+		}
+	}
+
+	if changes.dryRun {
+		p.Println("Dry run, nothing was changed.")
+		return nil
+	}
+	p.Printf("Switched to %s. Restart your browser for the change to take effect.\n", target)
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type uninstallResult struct {
+	Status  string   `json:"status"`
+	Error   string   `json:"error,omitempty"`
+	DryRun  bool     `json:"dryRun,omitempty"`
+	Changes []change `json:"changes,omitempty"`
+}
+
+func runUninstall(args []string) int {
+	fs := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	browserSelection := fs.String("browser", "all", "comma separated browsers to remove the manifest from, or \"all\" ("+strings.Join(browserNames(), ", ")+")")
+	hostName := fs.String("host-name", "", "native messaging host name (default "+defaultHostName+")")
+	userIDs := fs.String("user-id", "", "comma separated user ids whose stored keys are deleted")
+	keepPolicy := fs.Bool("keep-policy", false, "do not remove the polkit policy and PAM service")
+	dryRun := fs.Bool("dry-run", false, "only print what would be changed")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	p := newPrinter(*jsonOutput)
+	changes := &changeSet{p: p, dryRun: *dryRun}
+	res := &uninstallResult{Status: "ok", DryRun: *dryRun}
+	err := uninstall(p, changes, *browserSelection, *hostName, splitList(*userIDs), *keepPolicy)
+	res.Changes = changes.changes
+	if err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+		p.result(res)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	p.result(res)
+	return 0
+}
+
+func uninstall(p *printer, changes *changeSet, browserSelection string, hostName string, userIDs []string, keepPolicy bool) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("could not load config: %v", err)
+	}
+	manifestOpts := (&manifestFlags{hostName: hostName}).resolve(cfg, handlerPath())
+
+	home := os.Getenv("HOME")
+	selected, err := selectBrowsers(browserSelection, home)
+	if err != nil {
+		return err
+	}
+
+	p.Println("Uninstalling...")
+	seen := make(map[string]bool)
+	for _, b := range selected {
+		path := b.manifestPath(home, manifestOpts.fileName())
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		owned, err := isHandlerManifest(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err == nil && !owned {
+			p.Printf("Skipping %s, it does not belong to bw-bio-handler\n", path)
+			continue
+		}
+		if owned {
+			p.Printf("Removing manifest for %s: %s\n", b.displayName, path)
+			if err := changes.removeFile(path); err != nil {
+				return err
+			}
+		}
+		if _, err := restoreManifest(p, changes, b, manifestOpts.fileName(), false); err != nil {
+			return fmt.Errorf("failed to restore backup for %s: %v", b.displayName, err)
+		}
+	}
+
+	if len(userIDs) > 0 {
+		store, _, err := openSecretStore(cfg)
+		if err != nil {
+			return err
+		}
+		for _, userID := range userIDs {
+			p.Printf("Deleting the key for user %s...\n", userID)
+			if err := removeAccount(changes, store, userID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !keepPolicy {
+		if _, err := os.Stat(policyDir + policyName); err == nil {
+			p.Println("Removing polkit policy...")
+			if err := changes.runPrivileged("rm", policyDir+policyName); err != nil {
+				return fmt.Errorf("failed to remove polkit policy: %v", err)
+			}
+		}
+		// An adapted PAM service is left in place.
+		if isInstalledPAMService(os.Getenv("PWD")) {
+			p.Println("Removing PAM service...")
+			if err := changes.runPrivileged("rm", pamServiceDir+pamService); err != nil {
+				return fmt.Errorf("failed to remove PAM service: %v", err)
+			}
+		}
+	}
+
+	if changes.dryRun {
+		p.Println("Dry run, nothing was changed.")
+		return nil
+	}
+	p.Println("Done!")
+	return nil
+}
+
+// isHandlerManifest reports whether the manifest at path launches
+// bw-bio-handler, as opposed to e.g. the official desktop app's proxy.
+func isHandlerManifest(path string) (bool, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	var manifest struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(bs, &manifest); err != nil {
+		return false, nil
+	}
+	return filepath.Base(manifest.Path) == "bw-bio-handler", nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type unlockResetResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	DryRun bool   `json:"dryRun,omitempty"`
+	// Lifted are the user IDs whose lockout was lifted.
+	Lifted  []string `json:"lifted,omitempty"`
+	Changes []change `json:"changes,omitempty"`
+}
+
+// runUnlockReset lifts the lockout after too many failed authentications,
+// and lets callers prompt again right away.
+func runUnlockReset(args []string) int {
+	fs := flag.NewFlagSet("unlock-reset", flag.ContinueOnError)
+	userIDs := fs.String("user-id", "", "comma separated user ids whose lockout is lifted; defaults to all")
+	dryRun := fs.Bool("dry-run", false, "only print what would be changed")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: bw-bio-handler unlock-reset [flags]")
+		fs.PrintDefaults()
+		return 2
+	}
+
+	p := newPrinter(*jsonOutput)
+	changes := &changeSet{p: p, dryRun: *dryRun}
+	res := &unlockResetResult{Status: "ok", DryRun: *dryRun}
+	err := unlockReset(p, changes, splitList(*userIDs), res)
+	res.Changes = changes.changes
+	if err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+		p.result(res)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	p.result(res)
+	return 0
+}
+
+func unlockReset(p *printer, changes *changeSet, userIDs []string, res *unlockResetResult) error {
+	unlock, err := lockLimits()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	l, err := loadLimits()
+	if err != nil {
+		return err
+	}
+	res.Lifted = l.reset(userIDs)
+	summary := "reset all"
+	if len(userIDs) > 0 {
+		summary = "reset " + strings.Join(userIDs, ", ")
+	}
+	if err := changes.saveLimits(l, summary); err != nil {
+		return fmt.Errorf("failed to update the unlock limits: %v", err)
+	}
+
+	if len(res.Lifted) == 0 {
+		p.Println("No account was locked out.")
+	}
+	for _, userID := range res.Lifted {
+		p.Printf("Lifted the lockout of user %s.\n", userID)
+	}
+	if changes.dryRun {
+		p.Println("Dry run, nothing was changed.")
+		return nil
+	}
+	p.Println("Done!")
+	return nil
+}